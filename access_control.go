@@ -57,55 +57,65 @@ func (p *Permission) PermitWrite() []string {
 	return nil
 }
 
-// CanPerform check if a roler can access a resource (read/write)
-// If read is false, then it will check for write permission
-// It will return error if the check is failed
-func CanPerform(resource interface{}, request *http.Request, read bool) error {
-	unauthorized := errors.New("unauthorized access")
-
-	// If a resource does not define PermitRead and PermitWrite method,
-	// we assume it is public.
-	var roles []string
-	if read {
-		permitReader, ok := resource.(PermitReader)
-		if ok {
-			roles = permitReader.PermitRead()
-		}
-	} else {
-		permitWriter, ok := resource.(PermitWriter)
-		if ok {
-			roles = permitWriter.PermitWrite()
+// ErrUnauthorized is returned when current user is not allowed
+// to perform an action
+var ErrUnauthorized = errors.New("unauthorized access")
+
+// AdminRole is required to use admin paths, e.g. managing api keys
+var AdminRole = "admin"
+
+// hasRole checks if user has the role, either from Roler interface
+// or stored roles
+func hasRole(user interface{}, role string) bool {
+	for _, r := range UserRoles(user) {
+		if r == role {
+			return true
 		}
 	}
 
-	if len(roles) == 0 {
-		return nil
+	return false
+}
+
+// requireAdmin returns error if current user doesn't have AdminRole
+func requireAdmin(request *http.Request) (int, error) {
+	if IsMasterRequest(request) {
+		return 200, nil
 	}
 
-	// Retrieve role from current user
 	user, err := GetCurrentUser(request)
-	if err != nil {
-		return err
+	if err != nil || user == nil {
+		return 401, ErrNotLoggedIn
+	}
+
+	if !hasRole(user, AdminRole) {
+		return 403, ErrUnauthorized
 	}
 
-	var roler Roler
-	if user != nil {
-		roler, _ = user.(Roler)
+	return 200, nil
+}
+
+// CanPerform check if current user can access a resource (read/write)
+// If read is false, then it will check for write permission
+// It will return error if the check is failed. The decision is made
+// by SharedAuthorizer
+func CanPerform(resource interface{}, request *http.Request, read bool) error {
+	// Master key bypasses all permissions
+	if IsMasterRequest(request) {
+		return nil
 	}
 
-	// If roles is not defined, then this resource does not allow that action
-	if roler == nil || roles == nil {
-		return unauthorized
+	action := ActionWrite
+	if read {
+		action = ActionRead
 	}
 
-	// Check if roler has role inside permision
-	for _, change := range roles {
-		for _, role := range roler.Roles() {
-			if change == role {
-				return nil
-			}
-		}
+	// Anonymous requests have nil principal
+	principal, _ := GetCurrentUser(request)
+
+	authorizer := SharedAuthorizer
+	if authorizer == nil {
+		authorizer = &DefaultAuthorizer{}
 	}
 
-	return unauthorized
+	return authorizer.Authorize(principal, action, resource, request)
 }
@@ -2,9 +2,11 @@ package goal_test
 
 import (
 	"bytes"
+	encoding "encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"reflect"
 	"testing"
 
@@ -31,6 +33,14 @@ func (art *article) Query(w http.ResponseWriter, request *http.Request) (int, in
 	return goal.HandleQuery(reflect.TypeOf(art), request)
 }
 
+func (n *note) Get(w http.ResponseWriter, request *http.Request) (int, interface{}, error) {
+	return goal.Read(reflect.TypeOf(n), request)
+}
+
+func (n *note) Query(w http.ResponseWriter, request *http.Request) (int, interface{}, error) {
+	return goal.HandleQuery(reflect.TypeOf(n), request)
+}
+
 func TestCanRead(t *testing.T) {
 	setup()
 	defer tearDown()
@@ -82,3 +92,87 @@ func TestCanRead(t *testing.T) {
 		t.Error("Request should be unauthorized because thomasdao doesn't have admin role")
 	}
 }
+
+func TestACL(t *testing.T) {
+	setup()
+	defer tearDown()
+
+	res := httptest.NewRecorder()
+	var json = []byte(`{"username":"reader", "password": "something-secret"}`)
+	req, _ := http.NewRequest("POST", "/auth/register", bytes.NewBuffer(json))
+	goal.SharedAPI().Mux().ServeHTTP(res, req)
+	cookie := res.Header().Get("Set-Cookie")
+
+	var reader testuser
+	db.Where("username = ?", "reader").First(&reader)
+
+	public := &note{Text: "public"}
+	public.ACL = goal.NewACL()
+	public.ACL.SetPublicReadAccess(true)
+
+	members := &note{Text: "members"}
+	members.ACL = goal.NewACL()
+	members.ACL.SetReadAccess(goal.AuthenticatedACLKey, true)
+
+	private := &note{Text: "private"}
+	private.ACL = goal.NewACL()
+	private.ACL.SetReadAccess(goal.UserACLKey(reader.ID), true)
+
+	// Explicit deny wins over authenticated grant
+	denied := &note{Text: "denied"}
+	denied.ACL = goal.NewACL()
+	denied.ACL.SetReadAccess(goal.AuthenticatedACLKey, true)
+	denied.ACL.SetReadAccess(goal.RoleACLKey(fmt.Sprintf("testuser:%v", reader.ID)), false)
+
+	for _, n := range []*note{public, members, private, denied} {
+		if err := db.Create(n).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// ACL is stored in Parse format
+	var stored note
+	db.First(&stored, private.ID)
+	value, _ := stored.ACL.Value()
+	if value != fmt.Sprintf(`{"user:%v":{"read":true}}`, reader.ID) {
+		t.Error("Unexpected ACL format", value)
+	}
+
+	cases := []struct {
+		n      *note
+		cookie string
+		code   int
+	}{
+		{public, "", 200},
+		{members, "", 403},
+		{members, cookie, 200},
+		{private, "", 403},
+		{private, cookie, 200},
+		{denied, cookie, 403},
+	}
+
+	for _, c := range cases {
+		res = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", fmt.Sprint("/note/", c.n.ID), nil)
+		if c.cookie != "" {
+			req.Header.Add("Cookie", c.cookie)
+		}
+		goal.SharedAPI().Mux().ServeHTTP(res, req)
+
+		if res.Code != c.code {
+			t.Error("Unexpected status", c.n.Text, c.cookie != "", res.Code)
+		}
+	}
+
+	// Query only returns readable notes
+	res = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/query/note/"+url.QueryEscape(`{"where":[]}`), nil)
+	req.Header.Add("Cookie", cookie)
+	goal.SharedAPI().Mux().ServeHTTP(res, req)
+
+	var notes []note
+	encoding.Unmarshal(res.Body.Bytes(), &notes)
+	if len(notes) != 3 {
+		t.Error("Query should return 3 readable notes", res.Body)
+	}
+}
@@ -0,0 +1,204 @@
+package goal
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const (
+	// PublicACLKey grants access to everyone, including anonymous requests
+	PublicACLKey = "*"
+
+	// AuthenticatedACLKey grants access to every logged in user or api key
+	AuthenticatedACLKey = "authenticated"
+)
+
+// UserACLKey returns ACL key of a user, e.g. "user:12"
+func UserACLKey(id interface{}) string {
+	return fmt.Sprintf("user:%v", id)
+}
+
+// RoleACLKey returns ACL key of a role, e.g. "role:admin"
+func RoleACLKey(role string) string {
+	return fmt.Sprintf("role:%s", role)
+}
+
+// ACLEntry holds read and write access of one ACL key. A nil value
+// means not granted, false means explicitly denied
+type ACLEntry struct {
+	Read  *bool `json:"read,omitempty"`
+	Write *bool `json:"write,omitempty"`
+}
+
+// ACL is a Parse-style access control list, serialized as
+// {"*":{"read":true},"user:12":{"read":true,"write":true},"role:admin":{"write":true}}
+// An explicit deny always wins over grants
+type ACL map[string]*ACLEntry
+
+// NewACL returns an empty ACL, which denies everyone until
+// access is granted
+func NewACL() ACL {
+	return make(ACL)
+}
+
+func (acl ACL) entry(key string) *ACLEntry {
+	entry, ok := acl[key]
+	if !ok {
+		entry = &ACLEntry{}
+		acl[key] = entry
+	}
+
+	return entry
+}
+
+// SetReadAccess grants (true) or explicitly denies (false) read access
+func (acl ACL) SetReadAccess(key string, allowed bool) {
+	acl.entry(key).Read = &allowed
+}
+
+// SetWriteAccess grants (true) or explicitly denies (false) write access
+func (acl ACL) SetWriteAccess(key string, allowed bool) {
+	acl.entry(key).Write = &allowed
+}
+
+// SetPublicReadAccess lets everyone read the record
+func (acl ACL) SetPublicReadAccess(allowed bool) {
+	acl.SetReadAccess(PublicACLKey, allowed)
+}
+
+// SetPublicWriteAccess lets everyone write the record
+func (acl ACL) SetPublicWriteAccess(allowed bool) {
+	acl.SetWriteAccess(PublicACLKey, allowed)
+}
+
+// RevokeRead removes read grant or deny of the key
+func (acl ACL) RevokeRead(key string) {
+	if entry, ok := acl[key]; ok {
+		entry.Read = nil
+		acl.cleanup(key)
+	}
+}
+
+// RevokeWrite removes write grant or deny of the key
+func (acl ACL) RevokeWrite(key string) {
+	if entry, ok := acl[key]; ok {
+		entry.Write = nil
+		acl.cleanup(key)
+	}
+}
+
+// Revoke removes all grants and denies of the key
+func (acl ACL) Revoke(key string) {
+	delete(acl, key)
+}
+
+func (acl ACL) cleanup(key string) {
+	if entry := acl[key]; entry.Read == nil && entry.Write == nil {
+		delete(acl, key)
+	}
+}
+
+// aclKeys returns all ACL keys matching the user, nil user
+// only matches the public key
+func aclKeys(user interface{}) []string {
+	keys := []string{PublicACLKey}
+	if user == nil {
+		return keys
+	}
+
+	keys = append(keys, AuthenticatedACLKey)
+	if isUserModel(user) {
+		keys = append(keys, UserACLKey(userID(user)))
+	}
+
+	for _, role := range UserRoles(user) {
+		keys = append(keys, RoleACLKey(role))
+	}
+
+	return keys
+}
+
+// Allows checks if user can read or write. User can be nil
+// for anonymous requests
+func (acl ACL) Allows(user interface{}, read bool) bool {
+	allowed := false
+	for _, key := range aclKeys(user) {
+		entry, ok := acl[key]
+		if !ok {
+			continue
+		}
+
+		access := entry.Write
+		if read {
+			access = entry.Read
+		}
+
+		if access == nil {
+			continue
+		}
+
+		// Explicit deny wins
+		if !*access {
+			return false
+		}
+
+		allowed = true
+	}
+
+	return allowed
+}
+
+// Value conforms to driver.Valuer, ACL is stored as json string
+func (acl ACL) Value() (driver.Value, error) {
+	if acl == nil {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(acl)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(data), nil
+}
+
+// Scan conforms to sql.Scanner
+func (acl *ACL) Scan(value interface{}) error {
+	var data []byte
+	switch value := value.(type) {
+	case nil:
+		*acl = nil
+		return nil
+	case string:
+		data = []byte(value)
+	case []byte:
+		data = value
+	default:
+		return errors.New("invalid ACL value")
+	}
+
+	if len(data) == 0 {
+		*acl = nil
+		return nil
+	}
+
+	return json.Unmarshal(data, acl)
+}
+
+// ACLer is implemented by records protected by an ACL. A nil ACL
+// falls back to PermitReader and PermitWriter
+type ACLer interface {
+	GetACL() ACL
+}
+
+// ACLPermission can be embedded into a model to add an "acl" column
+type ACLPermission struct {
+	ACL ACL `sql:"type:text" json:"ACL"`
+}
+
+// GetACL conforms to ACLer interface
+func (p *ACLPermission) GetACL() ACL {
+	return p.ACL
+}
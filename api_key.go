@@ -0,0 +1,240 @@
+package goal
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// APIKeyHeader is the request header carrying an api key
+const APIKeyHeader = "X-Goal-API-Key"
+
+// ErrInvalidAPIKey is returned when api key is unknown, revoked or expired
+var ErrInvalidAPIKey = errors.New("invalid api key")
+
+// APIKey lets server-to-server clients authenticate without cookie
+// session. Only a SHA-256 hash of the key is stored, the plaintext
+// is returned once when the key is created
+type APIKey struct {
+	ID         uint       `gorm:"primary_key" json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `gorm:"unique_index" json:"prefix"`
+	Hash       string     `json:"-"`
+	RoleList   string     `gorm:"column:roles" json:"-"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// TableName conforms to gorm naming interface
+func (key *APIKey) TableName() string {
+	return "api_keys"
+}
+
+// Roles conforms to Roler interface, so permissions of records can be
+// granted to api keys. Every key also has its own "apikey:id" role
+func (key *APIKey) Roles() []string {
+	var roles []string
+	if key.RoleList != "" {
+		json.Unmarshal([]byte(key.RoleList), &roles)
+	}
+
+	return append(roles, fmt.Sprintf("apikey:%v", key.ID))
+}
+
+// MarshalJSON includes roles as array
+func (key *APIKey) MarshalJSON() ([]byte, error) {
+	type apiKey APIKey
+	return json.Marshal(&struct {
+		*apiKey
+		Roles []string `json:"roles"`
+	}{(*apiKey)(key), key.Roles()})
+}
+
+var apiKeysEnabled bool
+
+// EnableAPIKeys creates api key table and lets GetCurrentUser
+// authenticate requests having APIKeyHeader
+func EnableAPIKeys() {
+	db.AutoMigrate(&APIKey{})
+	apiKeysEnabled = true
+}
+
+func hashAPIKey(plaintext string) string {
+	sum := sha256.Sum256([]byte(plaintext))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAPIKey saves a new api key with fixed roles and returns it
+// with the plaintext key, which can't be retrieved later
+func CreateAPIKey(name string, roles []string, expiresAt *time.Time) (*APIKey, string, error) {
+	prefix := make([]byte, 6)
+	secret := make([]byte, 32)
+	_, err := rand.Read(prefix)
+	if err == nil {
+		_, err = rand.Read(secret)
+	}
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	key := &APIKey{Name: name, ExpiresAt: expiresAt}
+	key.Prefix = hex.EncodeToString(prefix)
+	plaintext := fmt.Sprintf("goal_%s_%s", key.Prefix, base64.RawURLEncoding.EncodeToString(secret))
+	key.Hash = hashAPIKey(plaintext)
+
+	if roles == nil {
+		roles = []string{}
+	}
+
+	data, err := json.Marshal(roles)
+	if err != nil {
+		return nil, "", err
+	}
+
+	key.RoleList = string(data)
+
+	err = db.Create(key).Error
+	if err != nil {
+		return nil, "", err
+	}
+
+	return key, plaintext, nil
+}
+
+// RevokeAPIKey marks the api key as revoked
+func RevokeAPIKey(id interface{}) error {
+	key := &APIKey{}
+	err := db.First(key, id).Error
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	return db.Model(key).Update("revoked_at", &now).Error
+}
+
+// authenticateAPIKey returns the api key matching plaintext if it is
+// valid, and records when it was used
+func authenticateAPIKey(ctx context.Context, plaintext string) (*APIKey, error) {
+	parts := strings.SplitN(plaintext, "_", 3)
+	if len(parts) != 3 || parts[0] != "goal" {
+		return nil, ErrInvalidAPIKey
+	}
+
+	key := &APIKey{}
+	err := contextDB(ctx).Where("prefix = ?", parts[1]).First(key).Error
+	if err != nil {
+		return nil, ErrInvalidAPIKey
+	}
+
+	if subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hashAPIKey(plaintext))) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+
+	now := time.Now()
+	if key.RevokedAt != nil || (key.ExpiresAt != nil && now.After(*key.ExpiresAt)) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	// Avoid writing to database on every request
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > time.Minute {
+		err = contextDB(ctx).Model(key).UpdateColumn("last_used_at", &now).Error
+		if err != nil {
+			fmt.Println("Unable to update api key", err)
+		}
+	}
+
+	return key, nil
+}
+
+// HandleAPIKeys lists (GET) and creates (POST) api keys. Only users
+// with AdminRole are allowed
+func HandleAPIKeys(w http.ResponseWriter, request *http.Request) (int, interface{}, error) {
+	code, err := requireAdmin(request)
+	if err != nil {
+		return code, nil, err
+	}
+
+	switch request.Method {
+	case GET:
+		var keys []*APIKey
+		err = db.Order("id").Find(&keys).Error
+		if err != nil {
+			return 500, nil, err
+		}
+
+		return 200, keys, nil
+	case POST:
+		var params struct {
+			Name      string     `json:"name"`
+			Roles     []string   `json:"roles"`
+			ExpiresAt *time.Time `json:"expires_at"`
+		}
+
+		err = json.NewDecoder(request.Body).Decode(&params)
+		if err != nil {
+			return 400, nil, err
+		}
+
+		key, plaintext, err := CreateAPIKey(params.Name, params.Roles, params.ExpiresAt)
+		if err != nil {
+			return 500, nil, err
+		}
+
+		result := map[string]interface{}{
+			"key":     plaintext,
+			"api_key": key,
+		}
+
+		return 200, result, nil
+	}
+
+	return 405, nil, http.ErrNotSupported
+}
+
+// HandleAPIKey revokes (DELETE) an api key. Only users with
+// AdminRole are allowed
+func HandleAPIKey(w http.ResponseWriter, request *http.Request) (int, interface{}, error) {
+	code, err := requireAdmin(request)
+	if err != nil {
+		return code, nil, err
+	}
+
+	if request.Method != DELETE {
+		return 405, nil, http.ErrNotSupported
+	}
+
+	err = RevokeAPIKey(mux.Vars(request)["id"])
+	if err != nil {
+		return 500, nil, err
+	}
+
+	return 200, nil, nil
+}
+
+// AddDefaultAPIKeyPaths enables api keys and routes admin requests
+// to manage them
+func (api *API) AddDefaultAPIKeyPaths() {
+	EnableAPIKeys()
+
+	api.Mux().HandleFunc("/admin/apikeys", func(rw http.ResponseWriter, request *http.Request) {
+		api.renderJSON(rw, request, nil, OperationAdmin, HandleAPIKeys)
+	})
+	api.Mux().HandleFunc("/admin/apikeys/{id:[0-9]+}", func(rw http.ResponseWriter, request *http.Request) {
+		api.renderJSON(rw, request, nil, OperationAdmin, HandleAPIKey)
+	})
+}
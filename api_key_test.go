@@ -0,0 +1,96 @@
+package goal_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thomasdao/goal"
+)
+
+func TestAPIKeys(t *testing.T) {
+	setup()
+	defer tearDown()
+
+	goal.SharedAPI().AddDefaultAPIKeyPaths()
+
+	// Bootstrap an admin key
+	_, adminKey, err := goal.CreateAPIKey("admin", []string{goal.AdminRole}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	send := func(method string, path string, body string, key string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set(goal.APIKeyHeader, key)
+		}
+		goal.SharedAPI().Mux().ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	// Only admin can manage keys
+	res := send("POST", "/admin/apikeys", `{"name":"worker","roles":["worker"]}`, "")
+	if res.Code == 200 {
+		t.Error("Anonymous request should not create api key")
+	}
+
+	res = send("POST", "/admin/apikeys", `{"name":"worker","roles":["worker"]}`, adminKey)
+	if res.Code != 200 {
+		t.Fatal("Fail to create api key", res.Body)
+	}
+
+	var created struct {
+		Key    string `json:"key"`
+		APIKey struct {
+			ID    uint     `json:"id"`
+			Roles []string `json:"roles"`
+		} `json:"api_key"`
+	}
+	json.Unmarshal(res.Body.Bytes(), &created)
+
+	art := &article{Title: "Internal"}
+	art.Permission = goal.Permission{Read: `["worker"]`}
+	db.Create(art)
+	artPath := fmt.Sprint("/article/", art.ID)
+
+	res = send("GET", artPath, "", created.Key)
+	if res.Code != 200 {
+		t.Error("Api key with worker role should read the article", res.Code, res.Body)
+	}
+
+	var key goal.APIKey
+	db.First(&key, created.APIKey.ID)
+	if key.LastUsedAt == nil {
+		t.Error("Last used time should be recorded")
+	}
+
+	res = send("GET", artPath, "", created.Key+"x")
+	if res.Code != 403 {
+		t.Error("Invalid api key should be rejected", res.Code)
+	}
+
+	// Revoke
+	res = send("DELETE", fmt.Sprint("/admin/apikeys/", created.APIKey.ID), "", adminKey)
+	if res.Code != 200 {
+		t.Fatal("Fail to revoke api key", res.Body)
+	}
+
+	res = send("GET", artPath, "", created.Key)
+	if res.Code != 403 {
+		t.Error("Revoked api key should be rejected", res.Code)
+	}
+
+	// Expired
+	expired := time.Now().Add(-time.Minute)
+	_, expiredKey, _ := goal.CreateAPIKey("expired", []string{"worker"}, &expired)
+	res = send("GET", artPath, "", expiredKey)
+	if res.Code != 403 {
+		t.Error("Expired api key should be rejected", res.Code)
+	}
+}
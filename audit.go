@@ -0,0 +1,59 @@
+package goal
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// AuditRecord describes a security related event, e.g. an account
+// being locked after too many failed logins
+type AuditRecord struct {
+	Time     time.Time `json:"time"`
+	Event    string    `json:"event"`
+	Username string    `json:"username,omitempty"`
+	IP       string    `json:"ip,omitempty"`
+	Detail   string    `json:"detail,omitempty"`
+}
+
+// Auditor stores audit records. Implement it to send records to
+// your database or log aggregation service
+type Auditor interface {
+	Audit(*AuditRecord)
+}
+
+// LogAuditor writes audit records as json to the standard logger
+type LogAuditor struct{}
+
+// Audit conforms to Auditor interface
+func (auditor *LogAuditor) Audit(record *AuditRecord) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		log.Println("goal audit:", err)
+		return
+	}
+
+	log.Println("goal audit:", string(data))
+}
+
+// SharedAuditor receives all audit records, default to LogAuditor
+var SharedAuditor Auditor = &LogAuditor{}
+
+// RegisterAuditor replaces SharedAuditor. Set it to nil to
+// disable audit records
+func RegisterAuditor(auditor Auditor) {
+	SharedAuditor = auditor
+}
+
+// audit sends the record to SharedAuditor if available
+func audit(record *AuditRecord) {
+	if SharedAuditor == nil {
+		return
+	}
+
+	if record.Time.IsZero() {
+		record.Time = time.Now()
+	}
+
+	SharedAuditor.Audit(record)
+}
@@ -17,6 +17,16 @@ type Logouter interface {
 	Logout(http.ResponseWriter, *http.Request) (int, interface{}, error)
 }
 
+// PasswordChanger lets current user change their password
+type PasswordChanger interface {
+	ChangePassword(http.ResponseWriter, *http.Request) (int, interface{}, error)
+}
+
+// UsernameChanger lets current user change their username
+type UsernameChanger interface {
+	ChangeUsername(http.ResponseWriter, *http.Request) (int, interface{}, error)
+}
+
 func (api *API) registerHandler(resource interface{}) http.HandlerFunc {
 	return func(rw http.ResponseWriter, request *http.Request) {
 		var handler simpleResponse
@@ -25,7 +35,7 @@ func (api *API) registerHandler(resource interface{}) http.HandlerFunc {
 			handler = resource.Register
 		}
 
-		renderJSON(rw, request, handler)
+		api.renderJSON(rw, request, resource, OperationAuth, handler)
 	}
 }
 
@@ -37,7 +47,7 @@ func (api *API) loginHandler(resource interface{}) http.HandlerFunc {
 			handler = resource.Login
 		}
 
-		renderJSON(rw, request, handler)
+		api.renderJSON(rw, request, resource, OperationAuth, handler)
 	}
 }
 
@@ -49,7 +59,31 @@ func (api *API) logoutHandler(resource interface{}) http.HandlerFunc {
 			handler = resource.Logout
 		}
 
-		renderJSON(rw, request, handler)
+		api.renderJSON(rw, request, resource, OperationAuth, handler)
+	}
+}
+
+func (api *API) changePasswordHandler(resource interface{}) http.HandlerFunc {
+	return func(rw http.ResponseWriter, request *http.Request) {
+		var handler simpleResponse
+
+		if resource, ok := resource.(PasswordChanger); ok {
+			handler = resource.ChangePassword
+		}
+
+		api.renderJSON(rw, request, resource, OperationAuth, handler)
+	}
+}
+
+func (api *API) changeUsernameHandler(resource interface{}) http.HandlerFunc {
+	return func(rw http.ResponseWriter, request *http.Request) {
+		var handler simpleResponse
+
+		if resource, ok := resource.(UsernameChanger); ok {
+			handler = resource.ChangeUsername
+		}
+
+		api.renderJSON(rw, request, resource, OperationAuth, handler)
 	}
 }
 
@@ -68,10 +102,22 @@ func (api *API) AddLogoutPath(resource interface{}, path string) {
 	api.Mux().Handle(path, api.logoutHandler(resource))
 }
 
+// AddChangePasswordPath let current user change password
+func (api *API) AddChangePasswordPath(resource interface{}, path string) {
+	api.Mux().Handle(path, api.changePasswordHandler(resource))
+}
+
+// AddChangeUsernamePath let current user change username
+func (api *API) AddChangeUsernamePath(resource interface{}, path string) {
+	api.Mux().Handle(path, api.changeUsernameHandler(resource))
+}
+
 // AddDefaultAuthPaths route request to the model which implement
 // authentications
 func (api *API) AddDefaultAuthPaths(resource interface{}) {
 	api.Mux().Handle("/auth/register", api.registerHandler(resource))
 	api.Mux().Handle("/auth/login", api.loginHandler(resource))
 	api.Mux().Handle("/auth/logout", api.logoutHandler(resource))
+	api.Mux().Handle("/auth/password", api.changePasswordHandler(resource))
+	api.Mux().Handle("/auth/username", api.changeUsernameHandler(resource))
 }
@@ -1,15 +1,52 @@
 package goal
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"sync"
 
 	"github.com/jinzhu/gorm"
-	"golang.org/x/crypto/bcrypt"
 )
 
+// ErrInvalidCredentials is returned by LoginWithPassword when username
+// does not exist or password is incorrect
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+// ErrNotLoggedIn is returned when an action requires current user
+var ErrNotLoggedIn = errors.New("user is not logged in")
+
+const (
+	// CurrentPasswordKey is the request body key of current password,
+	// required to change password or username
+	CurrentPasswordKey = "current_password"
+
+	// NewPasswordKey is the request body key of new password
+	NewPasswordKey = "new_password"
+)
+
+var (
+	dummyHash       string
+	dummyHashHasher PasswordHasher
+	dummyHashMutex  sync.Mutex
+)
+
+// dummyPasswordHash returns a valid hash of SharedPasswordHasher
+// which matches no password
+func dummyPasswordHash() string {
+	dummyHashMutex.Lock()
+	defer dummyHashMutex.Unlock()
+
+	if dummyHashHasher != SharedPasswordHasher {
+		dummyHash, _ = hashPassword("goal.dummy.password")
+		dummyHashHasher = SharedPasswordHasher
+	}
+
+	return dummyHash
+}
+
 // validateCols columns are valid
 func validateCols(usernameCol string, passwordCol string, user interface{}) error {
 	// validateCols column names
@@ -26,7 +63,7 @@ func validateCols(usernameCol string, passwordCol string, user interface{}) erro
 }
 
 // RegisterWithPassword checks if username exists and
-// sets password with bcrypt algorithm
+// sets password hashed by SharedPasswordHasher
 // Client can provides extra data to be saved into database for user
 func RegisterWithPassword(
 	w http.ResponseWriter, request *http.Request,
@@ -62,6 +99,11 @@ func RegisterWithPassword(
 		return nil, err
 	}
 
+	err = validatePassword(password)
+	if err != nil {
+		return nil, err
+	}
+
 	// Search db, if a username is already defined, return error
 	qryStr := fmt.Sprintf("%s = ?", usernameCol)
 	var count int
@@ -84,8 +126,8 @@ func RegisterWithPassword(
 	// Save a new record to db
 	scope.SetColumn(usernameCol, username)
 
-	// Hashing the password with the default cost of 10
-	hashedPw, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	// Hashing the password with SharedPasswordHasher
+	hashedPw, err := hashPassword(password)
 	if err != nil {
 		return nil, err
 	}
@@ -98,6 +140,8 @@ func RegisterWithPassword(
 	// Set current session
 	SetUserSession(w, request, user)
 
+	// User sees own record, except fields restricted to other roles
+	RedactFields(user, user)
 	return user, nil
 }
 
@@ -135,40 +179,261 @@ func LoginWithPassword(
 		return nil, errors.New("username or password is not found")
 	}
 
-	// Search db, if a username is not found, return error
+	// Refuse immediately if username or IP address is locked
+	ctx := request.Context()
+	throttle := SharedLoginThrottle
+	var ip string
+	if throttle != nil {
+		ip = throttle.clientIP(request)
+		retry := throttle.retryAfter(ctx, username, ip)
+		if retry > 0 {
+			setRetryAfter(w, retry)
+			return nil, &LoginLockedError{RetryAfter: retry}
+		}
+	}
+
+	// Search db, a missing username is reported the same way as a wrong
+	// password, so clients can't find out which usernames exist
 	qry := fmt.Sprintf("%s = ?", usernameCol)
 
-	qryDB := db.Where(qry, username).First(user)
+	qryDB := contextDB(ctx).Where(qry, username).First(user)
 	err = qryDB.Error
-	if err != nil {
+	found := err == nil
+	if err != nil && err != gorm.ErrRecordNotFound {
 		return nil, err
 	}
 
-	if user == nil {
-		errorMsg := fmt.Sprintf("Username not found: %s", username)
-		return nil, errors.New(errorMsg)
+	// Compare against a dummy hash when username is not found, so
+	// response time doesn't reveal it either
+	hashed := dummyPasswordHash()
+	if found {
+		// Make sure the password is correct
+		var hashs []string
+		qryDB.Pluck(passwordCol, &hashs)
+
+		if len(hashs) == 0 {
+			errorMsg := fmt.Sprintf("Unable to get value from column: %s", passwordCol)
+			return nil, errors.New(errorMsg)
+		}
+
+		hashed = hashs[0]
 	}
 
-	// Make sure the password is correct
+	// Comparing the password with the hash
+	rehash, err := comparePassword(hashed, password)
+	if !found || err != nil {
+		if throttle != nil {
+			throttle.fail(ctx, username, ip)
+		}
+		return nil, ErrInvalidCredentials
+	}
+
+	if throttle != nil {
+		throttle.succeed(ctx, username)
+	}
+
+	// Upgrade hash created by an outdated algorithm or cost
+	if rehash {
+		hashedPw, err := hashPassword(password)
+		if err == nil {
+			err = contextDB(ctx).Model(user).Update(passwordCol, hashedPw).Error
+		}
+
+		if err != nil {
+			fmt.Println("Unable to rehash password", err)
+		}
+	}
+
+	// Set current session
+	SetUserSession(w, request, user)
+
+	// User sees own record, except fields restricted to other roles
+	RedactFields(user, user)
+	return user, nil
+}
+
+// storedPasswordHash loads password hash of user from database,
+// since cached user may be outdated
+func storedPasswordHash(ctx context.Context, user interface{}, passwordCol string) (string, error) {
+	scope := db.NewScope(user)
+	qry := fmt.Sprintf("%s = ?", scope.Quote(scope.PrimaryKey()))
+
 	var hashs []string
-	qryDB.Pluck(passwordCol, &hashs)
+	err := contextDB(ctx).Table(scope.TableName()).Where(qry, scope.PrimaryKeyValue()).Pluck(passwordCol, &hashs).Error
+	if err != nil {
+		return "", err
+	}
 
 	if len(hashs) == 0 {
 		errorMsg := fmt.Sprintf("Unable to get value from column: %s", passwordCol)
-		return nil, errors.New(errorMsg)
+		return "", errors.New(errorMsg)
 	}
 
-	hashed := hashs[0]
+	return hashs[0], nil
+}
+
+// currentUserWithPassword returns current user after checking the
+// current password sent in request body. Wrong passwords count
+// against SharedLoginThrottle like failed logins
+func currentUserWithPassword(
+	w http.ResponseWriter, request *http.Request, usernameCol string, passwordCol string,
+	values map[string]string) (interface{}, error) {
+	user, err := GetCurrentUser(request)
+	if err != nil || user == nil {
+		return nil, ErrNotLoggedIn
+	}
 
-	// Comparing the password with the hash
-	err = bcrypt.CompareHashAndPassword([]byte(hashed), []byte(password))
+	err = validateCols(usernameCol, passwordCol, user)
 	if err != nil {
 		return nil, err
 	}
 
-	// Set current session
-	SetUserSession(w, request, user)
+	// Refuse immediately if username or IP address is locked
+	ctx := request.Context()
+	throttle := SharedLoginThrottle
+	var username, ip string
+	if throttle != nil {
+		if field, ok := db.NewScope(user).FieldByName(usernameCol); ok {
+			username = fmt.Sprint(field.Field.Interface())
+		}
+
+		ip = throttle.clientIP(request)
+		retry := throttle.retryAfter(ctx, username, ip)
+		if retry > 0 {
+			setRetryAfter(w, retry)
+			return nil, &LoginLockedError{RetryAfter: retry}
+		}
+	}
+
+	hashed, err := storedPasswordHash(ctx, user, passwordCol)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = comparePassword(hashed, values[CurrentPasswordKey])
+	if err != nil {
+		if throttle != nil {
+			throttle.fail(ctx, username, ip)
+		}
+		return nil, ErrInvalidCredentials
+	}
+
+	if throttle != nil {
+		throttle.succeed(ctx, username)
+	}
+
+	return user, nil
+}
+
+// ChangePassword sets a new password for current user. Client sends
+// current_password and new_password in request body. If invalidateSessions
+// is true, other sessions of the user are logged out
+func ChangePassword(
+	w http.ResponseWriter, request *http.Request,
+	usernameCol string, passwordCol string, invalidateSessions bool) (interface{}, error) {
+	if request.Method != POST {
+		return nil, http.ErrNotSupported
+	}
+
+	// Parse request body
+	decoder := json.NewDecoder(request.Body)
+	var values map[string]string
+	err := decoder.Decode(&values)
+	if err != nil {
+		return nil, err
+	}
+
+	password := values[NewPasswordKey]
+	if password == "" {
+		return nil, errors.New("new password is not found")
+	}
+
+	user, err := currentUserWithPassword(w, request, usernameCol, passwordCol, values)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validatePassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	hashedPw, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	err = contextDB(request.Context()).Model(user).Update(passwordCol, hashedPw).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if invalidateSessions {
+		err = InvalidateUserSessions(user)
+		if err != nil {
+			return nil, err
+		}
+
+		// Issue a new session, so current client stays logged in
+		err = SetUserSession(w, request, user)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// User sees own record, except fields restricted to other roles
+	RedactFields(user, user)
+	return user, nil
+}
+
+// ChangeUsername sets a new username for current user. Client sends
+// the new username under usernameCol key, with current_password
+func ChangeUsername(
+	w http.ResponseWriter, request *http.Request,
+	usernameCol string, passwordCol string) (interface{}, error) {
+	if request.Method != POST {
+		return nil, http.ErrNotSupported
+	}
+
+	// Parse request body
+	decoder := json.NewDecoder(request.Body)
+	var values map[string]string
+	err := decoder.Decode(&values)
+	if err != nil {
+		return nil, err
+	}
+
+	username := values[usernameCol]
+	if username == "" {
+		return nil, errors.New("username is not found")
+	}
+
+	user, err := currentUserWithPassword(w, request, usernameCol, passwordCol, values)
+	if err != nil {
+		return nil, err
+	}
+
+	// Make sure no other user owns the new username
+	scope := db.NewScope(user)
+	qry := fmt.Sprintf("%s = ? AND %s <> ?", usernameCol, scope.Quote(scope.PrimaryKey()))
+	ctxDB := contextDB(request.Context())
+	var count int
+	err = ctxDB.Table(scope.TableName()).Where(qry, username, scope.PrimaryKeyValue()).Count(&count).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if count > 0 {
+		return nil, errors.New("account already exists")
+	}
+
+	err = ctxDB.Model(user).Update(usernameCol, username).Error
+	if err != nil {
+		return nil, err
+	}
 
+	// User sees own record, except fields restricted to other roles
+	RedactFields(user, user)
 	return user, nil
 }
 
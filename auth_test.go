@@ -2,10 +2,13 @@ package goal_test
 
 import (
 	"bytes"
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/thomasdao/goal"
 )
@@ -24,6 +27,10 @@ func (user *testuser) Register(w http.ResponseWriter, req *http.Request) (int, i
 func (user *testuser) Login(w http.ResponseWriter, req *http.Request) (int, interface{}, error) {
 	currentUser, err := goal.LoginWithPassword(w, req, "username", "password")
 
+	if _, locked := err.(*goal.LoginLockedError); locked {
+		return 429, nil, err
+	}
+
 	if err != nil {
 		return 500, nil, err
 	}
@@ -36,6 +43,26 @@ func (user *testuser) Logout(w http.ResponseWriter, req *http.Request) (int, int
 	return 200, nil, nil
 }
 
+func (user *testuser) ChangePassword(w http.ResponseWriter, req *http.Request) (int, interface{}, error) {
+	currentUser, err := goal.ChangePassword(w, req, "username", "password", true)
+
+	if err != nil {
+		return 500, nil, err
+	}
+
+	return 200, currentUser, nil
+}
+
+func (user *testuser) ChangeUsername(w http.ResponseWriter, req *http.Request) (int, interface{}, error) {
+	currentUser, err := goal.ChangeUsername(w, req, "username", "password")
+
+	if err != nil {
+		return 500, nil, err
+	}
+
+	return 200, currentUser, nil
+}
+
 func TestAuth(t *testing.T) {
 	setup()
 	defer tearDown()
@@ -99,3 +126,195 @@ func TestAuth(t *testing.T) {
 	}
 
 }
+
+func TestLoginLockout(t *testing.T) {
+	setup()
+	defer tearDown()
+
+	throttle := goal.SharedLoginThrottle
+	defer goal.SetLoginThrottle(throttle)
+	goal.SetLoginThrottle(&goal.LoginThrottle{
+		MaxAttempts:        3,
+		Window:             time.Minute,
+		LockoutDuration:    time.Minute,
+		MaxLockoutDuration: time.Hour,
+	})
+
+	recorder := httptest.NewRecorder()
+	var json = []byte(`{"username":"lockme", "password": "secret-password"}`)
+	req, _ := http.NewRequest("POST", "/auth/register", bytes.NewBuffer(json))
+	goal.SharedAPI().Mux().ServeHTTP(recorder, req)
+
+	login := func(body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/auth/login", strings.NewReader(body))
+		req.RemoteAddr = "10.1.2.3:4567"
+		goal.SharedAPI().Mux().ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	// Unknown username and wrong password must look the same
+	unknown := login(`{"username":"nobody", "password": "wrong"}`)
+	wrong := login(`{"username":"lockme", "password": "wrong"}`)
+	if unknown.Code != wrong.Code || unknown.Body.String() != wrong.Body.String() {
+		t.Error("Response reveals whether username exists", unknown.Body, wrong.Body)
+	}
+
+	login(`{"username":"lockme", "password": "wrong"}`)
+	login(`{"username":"lockme", "password": "wrong"}`)
+
+	// Correct password is refused while account is locked
+	locked := login(`{"username":"lockme", "password": "secret-password"}`)
+	if locked.Code != 429 {
+		t.Fatal("Account should be locked, status:", locked.Code)
+	}
+
+	if locked.Header().Get("Retry-After") == "" {
+		t.Error("Retry-After header is missing")
+	}
+}
+
+func TestChangeCredentials(t *testing.T) {
+	setup()
+	defer tearDown()
+
+	send := func(path string, body string, cookie string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", path, strings.NewReader(body))
+		if cookie != "" {
+			req.Header.Add("Cookie", cookie)
+		}
+		goal.SharedAPI().Mux().ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	send("/auth/register", `{"username":"taken", "password": "secret-password"}`, "")
+	res := send("/auth/register", `{"username":"changeme", "password": "secret-password"}`, "")
+	oldCookie := res.Header().Get("Set-Cookie")
+	if oldCookie == "" {
+		t.Fatal("No cookies. Header:", res.Header())
+	}
+
+	// Current password is required
+	res = send("/auth/password", `{"current_password":"wrong", "new_password": "new-password"}`, oldCookie)
+	if res.Code == 200 {
+		t.Error("Password should not change without current password")
+	}
+
+	res = send("/auth/password", `{"current_password":"secret-password", "new_password": "new-password"}`, oldCookie)
+	if res.Code != 200 {
+		t.Fatal("Fail to change password", res.Body)
+	}
+
+	newCookie := res.Header().Get("Set-Cookie")
+	if newCookie == "" {
+		t.Fatal("Session should be renewed after password change")
+	}
+
+	// Password is stored as a bcrypt hash
+	var user testuser
+	db.Where("username = ?", "changeme").First(&user)
+	if user.Password == "new-password" || user.Password == "" {
+		t.Error("Password is not hashed")
+	}
+
+	// Other sessions are logged out
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Add("Cookie", oldCookie)
+	if _, err := goal.GetCurrentUser(req); err == nil {
+		t.Error("Old session should be invalidated")
+	}
+
+	res = send("/auth/login", `{"username":"changeme", "password": "new-password"}`, "")
+	if res.Code != 200 {
+		t.Error("Fail to login with new password", res.Body)
+	}
+
+	// Username must be unique
+	res = send("/auth/username", `{"username":"taken", "current_password": "new-password"}`, newCookie)
+	if res.Code == 200 {
+		t.Error("Username should be unique")
+	}
+
+	res = send("/auth/username", `{"username":"changed", "current_password": "new-password"}`, newCookie)
+	if res.Code != 200 {
+		t.Error("Fail to change username", res.Body)
+	}
+}
+
+func TestChangePasswordThrottle(t *testing.T) {
+	setup()
+	defer tearDown()
+
+	throttle := goal.SharedLoginThrottle
+	defer goal.SetLoginThrottle(throttle)
+	goal.SetLoginThrottle(&goal.LoginThrottle{
+		MaxAttempts:        2,
+		Window:             time.Minute,
+		LockoutDuration:    time.Minute,
+		MaxLockoutDuration: time.Hour,
+	})
+
+	send := func(path string, body string, cookie string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", path, strings.NewReader(body))
+		req.RemoteAddr = "10.1.2.4:4567"
+		if cookie != "" {
+			req.Header.Add("Cookie", cookie)
+		}
+		goal.SharedAPI().Mux().ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	res := send("/auth/register", `{"username":"guessme", "password": "secret-password"}`, "")
+	cookie := res.Header().Get("Set-Cookie")
+	if cookie == "" {
+		t.Fatal("No cookies. Header:", res.Header())
+	}
+
+	send("/auth/password", `{"current_password":"wrong", "new_password": "new-password"}`, cookie)
+	send("/auth/password", `{"current_password":"wrong", "new_password": "new-password"}`, cookie)
+
+	// Correct password is refused while account is locked
+	res = send("/auth/password", `{"current_password":"secret-password", "new_password": "new-password"}`, cookie)
+	if res.Code == 200 {
+		t.Error("Password should not change while account is locked")
+	}
+
+	if res.Header().Get("Retry-After") == "" {
+		t.Error("Retry-After header is missing")
+	}
+}
+
+func TestInvalidatedSessionsDontExpire(t *testing.T) {
+	setup()
+	defer tearDown()
+
+	previous := goal.SharedCache
+	defer goal.RegisterCacher(previous)
+
+	// Default TTL and eviction of the cacher must not drop the marker
+	cache := goal.NewMemoryCache(1, 0)
+	cache.TTL = time.Nanosecond
+	goal.RegisterCacher(cache)
+
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/auth/register", strings.NewReader(`{"username":"revoked", "password": "secret-password"}`))
+	goal.SharedAPI().Mux().ServeHTTP(recorder, req)
+	cookie := recorder.Header().Get("Set-Cookie")
+
+	var user testuser
+	db.Where("username = ?", "revoked").First(&user)
+	if err := goal.InvalidateUserSessions(&user); err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set(context.Background(), "filler:1", 1)
+	cache.Set(context.Background(), "filler:2", 2)
+
+	req, _ = http.NewRequest("GET", "/", nil)
+	req.Header.Add("Cookie", cookie)
+	if _, err := goal.GetCurrentUser(req); err == nil {
+		t.Error("Invalidated session should stay invalid")
+	}
+}
@@ -0,0 +1,95 @@
+package goal
+
+import "net/http"
+
+// Action is the operation being authorized
+type Action string
+
+// Actions checked by CanPerform
+const (
+	ActionRead  Action = "read"
+	ActionWrite Action = "write"
+)
+
+// Authorizer decides if a principal, usually current user or api key,
+// can perform an action on a resource. Principal is nil for anonymous
+// requests. Return nil to allow, or an error to deny
+type Authorizer interface {
+	Authorize(principal interface{}, action Action, resource interface{}, request *http.Request) error
+}
+
+// DefaultAuthorizer implements owner permissions, ACL, and role
+// lists from PermitReader and PermitWriter
+type DefaultAuthorizer struct{}
+
+// Authorize conforms to Authorizer interface
+func (authorizer *DefaultAuthorizer) Authorize(
+	principal interface{}, action Action, resource interface{}, request *http.Request) error {
+	read := action == ActionRead
+
+	// User referenced by a pointer permission field is always allowed
+	fields := pointerFields(resource, read)
+	if isOwner(resource, principal, fields) {
+		return nil
+	}
+
+	// ACL takes precedence over PermitRead and PermitWrite
+	if aclr, ok := resource.(ACLer); ok {
+		if acl := aclr.GetACL(); acl != nil {
+			if acl.Allows(principal, read) {
+				return nil
+			}
+
+			return ErrUnauthorized
+		}
+	}
+
+	// If a resource does not define PermitRead and PermitWrite method,
+	// we assume it is public.
+	var roles []string
+	if read {
+		permitReader, ok := resource.(PermitReader)
+		if ok {
+			roles = permitReader.PermitRead()
+		}
+	} else {
+		permitWriter, ok := resource.(PermitWriter)
+		if ok {
+			roles = permitWriter.PermitWrite()
+		}
+	}
+
+	// Action restricted by pointer permission is not public
+	if len(roles) == 0 {
+		if len(fields) > 0 {
+			return ErrUnauthorized
+		}
+
+		return nil
+	}
+
+	// If roles is not defined, then this resource does not allow that action
+	if principal == nil {
+		return ErrUnauthorized
+	}
+
+	// Check if principal has role inside permision
+	userRoles := contextUserRoles(requestContext(request), principal)
+	for _, change := range roles {
+		for _, role := range userRoles {
+			if change == role {
+				return nil
+			}
+		}
+	}
+
+	return ErrUnauthorized
+}
+
+// SharedAuthorizer makes all decisions of CanPerform
+var SharedAuthorizer Authorizer = &DefaultAuthorizer{}
+
+// SetAuthorizer replaces SharedAuthorizer
+func SetAuthorizer(authorizer Authorizer) {
+	SharedAuthorizer = authorizer
+}
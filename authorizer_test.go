@@ -0,0 +1,185 @@
+package goal_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/thomasdao/goal"
+)
+
+func TestDefaultAuthorizer(t *testing.T) {
+	setup()
+	defer tearDown()
+
+	authorizer := &goal.DefaultAuthorizer{}
+	req, _ := http.NewRequest("GET", "/post/1", nil)
+
+	author := &testuser{ID: 7, Username: "author"}
+	p := &post{ID: 1, AuthorID: 7}
+
+	if err := authorizer.Authorize(author, goal.ActionRead, p, req); err != nil {
+		t.Fatal("Author should read own post", err)
+	}
+
+	if err := authorizer.Authorize(&testuser{ID: 8}, goal.ActionWrite, p, req); err != goal.ErrUnauthorized {
+		t.Fatal("Other user should not write post", err)
+	}
+
+	if err := authorizer.Authorize(nil, goal.ActionRead, &testuser{}, req); err != nil {
+		t.Fatal("Model without permissions should be public", err)
+	}
+}
+
+func TestRuleAuthorizer(t *testing.T) {
+	setup()
+	defer tearDown()
+
+	auditor := &recordingAuditor{}
+	goal.RegisterAuditor(auditor)
+	defer goal.RegisterAuditor(&goal.LogAuditor{})
+
+	rules := []*goal.Rule{
+		{
+			Name:      "locked",
+			Resource:  "post",
+			Action:    "write",
+			Effect:    goal.EffectDeny,
+			Condition: `resource.Title == "locked"`,
+		},
+		{
+			Name:      "owner",
+			Resource:  "post",
+			Action:    "*",
+			Effect:    goal.EffectAllow,
+			Condition: `principal.id == resource.AuthorID`,
+		},
+		{
+			Name:      "editors",
+			Resource:  "*",
+			Effect:    goal.EffectAllow,
+			Condition: `"editor" in principal.roles && (principal.Age >= 18 || request.method == "GET")`,
+		},
+	}
+
+	authorizer, err := goal.NewRuleAuthorizer(rules, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorizer.LogDecisions = true
+
+	get, _ := http.NewRequest("GET", "/post/1", nil)
+	put, _ := http.NewRequest("PUT", "/post/1", nil)
+
+	author := &testuser{ID: 7, Username: "author", Age: 30}
+	other := &testuser{ID: 8, Username: "other", Age: 30}
+
+	cases := []struct {
+		principal interface{}
+		action    goal.Action
+		resource  interface{}
+		request   *http.Request
+		allowed   bool
+	}{
+		{author, goal.ActionRead, &post{AuthorID: 7, Title: "locked"}, get, true},
+		{author, goal.ActionWrite, &post{AuthorID: 7, Title: "locked"}, put, false},
+		{author, goal.ActionWrite, &post{AuthorID: 7, Title: "open"}, put, true},
+		{other, goal.ActionRead, &post{AuthorID: 7}, get, false},
+		{nil, goal.ActionRead, &post{AuthorID: 7}, get, false},
+	}
+
+	for i, c := range cases {
+		err := authorizer.Authorize(c.principal, c.action, c.resource, c.request)
+		if (err == nil) != c.allowed {
+			t.Error("Unexpected decision of case", i, err)
+		}
+	}
+
+	if len(auditor.records) != len(cases) || auditor.records[0].Event != "authz.decision" {
+		t.Fatal("Decisions should be logged", auditor.records)
+	}
+
+	// Unmatched requests go to fallback
+	authorizer.Fallback = &goal.DefaultAuthorizer{}
+	if err := authorizer.Authorize(nil, goal.ActionRead, &testuser{}, get); err != nil {
+		t.Error("Fallback should allow public model", err)
+	}
+
+	if _, err := goal.NewRuleAuthorizer([]*goal.Rule{{Name: "bad", Effect: "allow", Condition: "a == "}}, nil); err == nil {
+		t.Error("Invalid condition should fail")
+	}
+
+	if err := authorizer.AddRule(&goal.Rule{Name: "bad", Effect: "allow", Condition: "a == "}); err == nil {
+		t.Error("Invalid rule should not be added")
+	}
+}
+
+func TestRuleEvaluationErrorDenies(t *testing.T) {
+	setup()
+	defer tearDown()
+
+	authorizer, err := goal.NewRuleAuthorizer(nil, &goal.DefaultAuthorizer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Deny rule referencing an unknown attribute can't be evaluated
+	err = authorizer.AddRule(&goal.Rule{
+		Name:      "banned",
+		Effect:    goal.EffectDeny,
+		Condition: `session.banned == true`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get, _ := http.NewRequest("GET", "/testuser/1", nil)
+	if err := authorizer.Authorize(nil, goal.ActionRead, &testuser{}, get); err != goal.ErrUnauthorized {
+		t.Error("Rule which fails to evaluate should deny", err)
+	}
+
+	// Rules appended without compiling deny as well
+	authorizer.Rules = []*goal.Rule{{Name: "raw", Effect: goal.EffectAllow, Condition: "true"}}
+	if err := authorizer.Authorize(nil, goal.ActionRead, &testuser{}, get); err != goal.ErrUnauthorized {
+		t.Error("Uncompiled rule should deny", err)
+	}
+}
+
+func TestLoadRuleAuthorizer(t *testing.T) {
+	setup()
+	defer tearDown()
+
+	dir, _ := ioutil.TempDir("", "goal")
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "rules.json")
+	config := `{"rules": [
+		{"name": "archived-readonly", "resource": "post", "action": "write",
+		 "effect": "deny", "condition": "resource.Title in ['done', 'archived']"},
+		{"name": "everyone", "resource": "*", "effect": "allow", "condition": "!(action == 'write' && principal == null)"}
+	]}`
+	ioutil.WriteFile(path, []byte(config), 0600)
+
+	authorizer, err := goal.LoadRuleAuthorizer(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	goal.SetAuthorizer(authorizer)
+	defer goal.SetAuthorizer(&goal.DefaultAuthorizer{})
+
+	req, _ := http.NewRequest("PUT", "/post/1", nil)
+	if err := goal.CanPerform(&post{Title: "archived"}, req, false); err == nil {
+		t.Error("Archived post should not be writable")
+	}
+
+	if err := goal.CanPerform(&post{Title: "draft"}, req, true); err != nil {
+		t.Error("Anonymous read should be allowed", err)
+	}
+
+	if err := goal.CanPerform(&post{Title: "draft"}, req, false); err == nil {
+		t.Error("Anonymous write should be denied")
+	}
+}
@@ -0,0 +1,79 @@
+package goal
+
+import (
+	"context"
+	"time"
+)
+
+// BatchCacher is implemented by cachers which read and write many keys
+// in one round trip, like RedisCache with MGET and pipelines
+type BatchCacher interface {
+	Cacher
+
+	// GetMulti decodes the value of keys[i] into vals[i] and reports
+	// which keys were found
+	GetMulti(ctx context.Context, keys []string, vals []interface{}) ([]bool, error)
+
+	// SetMulti stores vals[i] at keys[i] for ttl, zero ttl uses the
+	// default expiry of the cacher
+	SetMulti(ctx context.Context, keys []string, vals []interface{}, ttl time.Duration) error
+
+	DeleteMulti(ctx context.Context, keys []string) error
+}
+
+// getMulti reads many keys, one by one if the cacher doesn't support
+// batches
+func getMulti(ctx context.Context, cache Cacher, keys []string, vals []interface{}) ([]bool, error) {
+	if batch, ok := cache.(BatchCacher); ok {
+		return batch.GetMulti(ctx, keys, vals)
+	}
+
+	found := make([]bool, len(keys))
+	for i, key := range keys {
+		err := cache.Get(ctx, key, vals[i])
+		if err == nil {
+			found[i] = true
+			continue
+		}
+
+		if err != ErrCacheMiss {
+			return found, err
+		}
+	}
+
+	return found, nil
+}
+
+// setMulti stores many keys, one by one if the cacher doesn't support
+// batches
+func setMulti(ctx context.Context, cache Cacher, keys []string, vals []interface{}, ttl time.Duration) error {
+	if batch, ok := cache.(BatchCacher); ok {
+		return batch.SetMulti(ctx, keys, vals, ttl)
+	}
+
+	for i, key := range keys {
+		err := setWithTTL(ctx, cache, key, vals[i], ttl)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteMulti deletes many keys, one by one if the cacher doesn't
+// support batches
+func deleteMulti(ctx context.Context, cache Cacher, keys []string) error {
+	if batch, ok := cache.(BatchCacher); ok {
+		return batch.DeleteMulti(ctx, keys)
+	}
+
+	for _, key := range keys {
+		err := cache.Delete(ctx, key)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
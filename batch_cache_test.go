@@ -0,0 +1,142 @@
+package goal_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/thomasdao/goal"
+)
+
+func TestBatchCache(t *testing.T) {
+	ctx := context.Background()
+	l2 := goal.NewMemoryCache(0, 0)
+	caches := []goal.BatchCacher{goal.NewMemoryCache(0, 0), goal.NewTwoTierCache(goal.NewMemoryCache(0, 0), l2)}
+
+	for _, cache := range caches {
+		keys := []string{"testuser:1", "testuser:2", "testuser:3"}
+		err := cache.SetMulti(ctx, keys[:2], []interface{}{&testuser{ID: 1}, &testuser{ID: 2}}, 0)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+
+		vals := []interface{}{&testuser{}, &testuser{}, &testuser{}}
+		found, err := cache.GetMulti(ctx, keys, vals)
+		if err != nil || !found[0] || !found[1] || found[2] {
+			t.Error("Stored keys should be found", found, err)
+		}
+
+		if vals[1].(*testuser).ID != 2 {
+			t.Error("Values should be decoded in order", vals[1])
+		}
+
+		cache.DeleteMulti(ctx, keys)
+		found, _ = cache.GetMulti(ctx, keys, vals)
+		if found[0] || found[1] {
+			t.Error("Deleted keys should not be found", found)
+		}
+	}
+
+	// Keys missing from L1 are promoted from L2
+	cache := goal.NewTwoTierCache(goal.NewMemoryCache(0, 0), l2)
+	l2.Set(ctx, "testuser:4", &testuser{ID: 4})
+	vals := []interface{}{&testuser{}}
+	cache.GetMulti(ctx, []string{"testuser:4"}, vals)
+	cache.GetMulti(ctx, []string{"testuser:4"}, vals)
+
+	if stats := cache.Stats(); stats.L1Hits != 1 || stats.L2Hits != 1 {
+		t.Error("Value should be promoted to L1", stats)
+	}
+}
+
+// reply has a nullable belongs to relation
+type reply struct {
+	ID     uint `gorm:"primary_key"`
+	PostID *uint
+	Post   *post
+	Text   string
+}
+
+func (r *reply) Query(w http.ResponseWriter, request *http.Request) (int, interface{}, error) {
+	return goal.HandleQuery(reflect.TypeOf(r), request)
+}
+
+func TestQueryRowsFromCache(t *testing.T) {
+	setup()
+	defer tearDown()
+
+	parent := &post{Title: "Parent"}
+	db.Create(parent)
+	first := &comment{PostID: parent.ID, Text: "First"}
+	db.Create(first)
+	db.Create(&comment{PostID: parent.ID, Text: "Second"})
+	db.Create(&comment{Text: "Orphan"})
+
+	query := "/query/comment/" + url.QueryEscape(`{"where":[],"order":{"id":true},"include":["Post"]}`)
+	find := func() []comment {
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", query, nil)
+		goal.SharedAPI().Mux().ServeHTTP(recorder, req)
+
+		var results []comment
+		json.Unmarshal(recorder.Body.Bytes(), &results)
+		return results
+	}
+
+	comments, doneComments := countQueries("comment", 0)
+	defer doneComments()
+
+	posts, donePosts := countQueries("post", 0)
+	defer donePosts()
+
+	// Created rows are cached
+	results := find()
+	if len(results) != 3 || results[0].Post == nil || results[0].Post.Title != "Parent" || results[2].Post != nil {
+		t.Fatal("Fail to query comments", results)
+	}
+
+	if *comments != 0 || *posts != 0 {
+		t.Error("Rows should be read from cache", *comments, *posts)
+	}
+
+	// Missing rows are loaded in one query
+	goal.SharedCache.Delete(context.Background(), goal.CacheKey(first))
+	goal.SharedCache.Delete(context.Background(), goal.CacheKey(parent))
+
+	results = find()
+	if len(results) != 3 || results[0].Text != "First" || results[1].Post == nil {
+		t.Error("Missing rows should be loaded", results)
+	}
+
+	if *comments != 1 || *posts != 1 {
+		t.Error("Missing rows should be loaded in one query", *comments, *posts)
+	}
+}
+
+func TestQueryRowsNullableForeignKey(t *testing.T) {
+	setup()
+	defer tearDown()
+
+	goal.RegisterModel(&reply{})
+
+	parent := &post{Title: "Parent"}
+	db.Create(parent)
+	db.Create(&reply{PostID: &parent.ID, Text: "Linked"})
+	db.Create(&reply{Text: "Orphan"})
+
+	query := "/query/reply/" + url.QueryEscape(`{"where":[],"order":{"id":true},"include":["Post"]}`)
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", query, nil)
+	goal.SharedAPI().Mux().ServeHTTP(recorder, req)
+
+	var results []reply
+	json.Unmarshal(recorder.Body.Bytes(), &results)
+	if len(results) != 2 || results[0].Post == nil || results[0].Post.Title != "Parent" || results[1].Post != nil {
+		t.Error("Relation of pointer foreign key should be loaded", recorder.Body)
+	}
+}
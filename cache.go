@@ -1,17 +1,116 @@
 package goal
 
 import (
+	"context"
 	"fmt"
+	"reflect"
+	"time"
 
 	"github.com/jinzhu/gorm"
 )
 
-// Cacher defines a interface for fast key-value caching
+// Cacher defines a interface for fast key-value caching. The context
+// is the one of current request, or background context for work
+// outside of a request
 type Cacher interface {
-	Get(string, interface{}) error
-	Set(string, interface{}) error
-	Delete(string) error
-	Exists(string) (bool, error)
+	Get(context.Context, string, interface{}) error
+	Set(context.Context, string, interface{}) error
+	Delete(context.Context, string) error
+	Exists(context.Context, string) (bool, error)
+}
+
+// ExpiringCacher is implemented by cachers supporting expiry,
+// like RedisCache and MemoryCache
+type ExpiringCacher interface {
+	Cacher
+
+	// SetWithTTL stores the value for ttl, zero ttl means no expiry
+	SetWithTTL(context.Context, string, interface{}, time.Duration) error
+
+	// Touch resets expiry of an existing key
+	Touch(context.Context, string, time.Duration) error
+}
+
+// CacheOptions configures caching of a model
+type CacheOptions struct {
+	// TTL is expiry of cached records, zero uses default of the cacher
+	TTL time.Duration
+
+	// Sliding resets expiry every time the record is read from cache
+	Sliding bool
+
+	// Version is the schema version of cached records. Bump it when
+	// the model changes, entries of other versions are cache misses
+	Version int
+
+	// Disabled opts the model out of caching, records are always read
+	// from database
+	Disabled bool
+}
+
+// CacheOptioner lets a model declare its CacheOptions
+type CacheOptioner interface {
+	CacheOptions() CacheOptions
+}
+
+// cacheOptions returns options declared by the model of a value,
+// which may also be a slice of records
+func cacheOptions(resource interface{}) CacheOptions {
+	if optioner, ok := resource.(CacheOptioner); ok {
+		return optioner.CacheOptions()
+	}
+
+	t := reflect.TypeOf(resource)
+	for t != nil && (t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+		t = t.Elem()
+	}
+
+	if t == nil || t.Kind() != reflect.Struct {
+		return CacheOptions{}
+	}
+
+	if optioner, ok := reflect.New(t).Interface().(CacheOptioner); ok {
+		return optioner.CacheOptions()
+	}
+
+	return CacheOptions{}
+}
+
+// setWithTTL stores the value with expiry if the cacher supports it
+func setWithTTL(ctx context.Context, cache Cacher, key string, val interface{}, ttl time.Duration) error {
+	if expiring, ok := cache.(ExpiringCacher); ok && ttl > 0 {
+		return expiring.SetWithTTL(ctx, key, val, ttl)
+	}
+
+	return cache.Set(ctx, key, val)
+}
+
+// setWithExactTTL stores the value for ttl, zero ttl means no expiry
+// even if the cacher has a default TTL
+func setWithExactTTL(ctx context.Context, cache Cacher, key string, val interface{}, ttl time.Duration) error {
+	if expiring, ok := cache.(ExpiringCacher); ok {
+		return expiring.SetWithTTL(ctx, key, val, ttl)
+	}
+
+	return cache.Set(ctx, key, val)
+}
+
+// cacheResource stores a record with TTL declared by its model
+func cacheResource(ctx context.Context, key string, resource interface{}) error {
+	return setWithTTL(ctx, SharedCache, key, resource, cacheOptions(resource).TTL)
+}
+
+// touchResource extends expiry of a record read from cache, if its
+// model uses sliding expiration
+func touchResource(ctx context.Context, key string, resource interface{}) {
+	options := cacheOptions(resource)
+	if !options.Sliding || options.TTL <= 0 {
+		return
+	}
+
+	if expiring, ok := SharedCache.(ExpiringCacher); ok {
+		reportCacheError("touch", key, expiring.Touch(ctx, key, options.TTL))
+	}
 }
 
 // SharedCache is global variable to cache data
@@ -21,15 +120,52 @@ var SharedCache Cacher
 func RegisterCacher(cache Cacher) {
 	SharedCache = cache
 
-	if SharedCache != nil {
-		// Register Gorm callbacks
-		if db != nil {
-			db.Callback().Create().After("gorm:after_create").Register("goal:cache_after_create", Cache)
-			db.Callback().Update().After("gorm:after_update").Register("goal:cache_after_update", Cache)
-			db.Callback().Query().After("gorm:after_query").Register("goal:cache_after_query", Cache)
-			db.Callback().Delete().Before("gorm:before_delete").Register("goal:uncache_after_delete", Uncache)
-		}
+	if SharedCache != nil && db != nil {
+		registerCacheCallbacks()
+	}
+}
+
+// NegativeCacheTTL is how long a record looked up and not found is
+// remembered, so repeated reads of a missing id don't query the
+// database. Zero disables negative caching
+var NegativeCacheTTL = 10 * time.Second
+
+func notFoundKey(name string, id interface{}) string {
+	return namespacedKey(fmt.Sprintf("goal:notfound:%v:%v", name, id))
+}
+
+// rememberNotFound stores a short-lived marker for a missing record.
+// Only cachers with expiry are used, a marker without expiry would
+// hide records created outside of gorm callbacks forever
+func rememberNotFound(ctx context.Context, name string, id interface{}) {
+	if NegativeCacheTTL <= 0 {
+		return
+	}
+
+	if expiring, ok := SharedCache.(ExpiringCacher); ok {
+		key := notFoundKey(name, id)
+		reportCacheError("set", key, expiring.SetWithTTL(ctx, key, true, NegativeCacheTTL))
+	}
+}
+
+// isNotFound checks if a missing record is remembered
+func isNotFound(ctx context.Context, name string, id interface{}) bool {
+	if NegativeCacheTTL <= 0 {
+		return false
+	}
+
+	exists, err := SharedCache.Exists(ctx, notFoundKey(name, id))
+	return err == nil && exists
+}
+
+// forgetNotFound removes the marker of a created record
+func forgetNotFound(scope *gorm.Scope) {
+	if SharedCache == nil || NegativeCacheTTL <= 0 || scope.HasError() || scope.PrimaryKeyZero() {
+		return
 	}
+
+	key := notFoundKey(scope.TableName(), scope.PrimaryKeyValue())
+	reportCacheError("delete", key, SharedCache.Delete(scopeContext(scope), key))
 }
 
 func cacheKeyFromScope(scope *gorm.Scope) string {
@@ -46,23 +182,8 @@ func CacheKey(resource interface{}) string {
 	return cacheKeyFromScope(scope)
 }
 
-// DefaultCacheKey returns default format for redis key
+// DefaultCacheKey returns default format for redis key, prefixed by
+// the cache namespace
 func DefaultCacheKey(name string, id interface{}) string {
-	return fmt.Sprintf("%v:%v", name, id)
-}
-
-// Uncache data from cacher
-func Uncache(scope *gorm.Scope) {
-	// Reload object before delete
-	scope.DB().New().First(scope.Value)
-
-	// Delete from redis
-	key := cacheKeyFromScope(scope)
-	SharedCache.Delete(key)
-}
-
-// Cache data to cacher
-func Cache(scope *gorm.Scope) {
-	key := cacheKeyFromScope(scope)
-	SharedCache.Set(key, scope.Value)
+	return namespacedKey(fmt.Sprintf("%v:%v", name, id))
 }
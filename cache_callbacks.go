@@ -0,0 +1,232 @@
+package goal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// Keys of records changed by an update or delete, collected before
+	// the statement runs
+	cacheKeysInstanceKey = "goal:cache_keys"
+
+	// Set on queries whose rows must not be cached
+	skipCacheSettingKey = "goal:skip_cache"
+)
+
+// CacheErrorHandler is called when SharedCache fails to read, store,
+// touch or delete a key on behalf of goal, or to publish changed keys
+// to the invalidation bus or receive them, or to decode a cached
+// entry. op is "get", "set", "touch", "delete", "publish", "subscribe"
+// or "decode", keys of batches are separated by spaces. Cache misses
+// are not reported
+var CacheErrorHandler = func(op string, key string, err error) {
+	fmt.Println("Unable to", op, "cache key", key, err)
+}
+
+// reportCacheError passes a cache failure to CacheErrorHandler
+func reportCacheError(op string, key string, err error) {
+	if err == nil || err == ErrCacheMiss || CacheErrorHandler == nil {
+		return
+	}
+
+	CacheErrorHandler(op, key, err)
+}
+
+// registerCacheCallbacks keeps SharedCache consistent with changes
+// made through gorm. Records are cached and invalidated once gorm
+// commits the transaction of the statement. Inside a transaction of
+// the caller, e.g. db.Begin(), nothing is cached since it may be
+// rolled back, changed records are only deleted from cache
+func registerCacheCallbacks() {
+	db.Callback().Create().After("gorm:commit_or_rollback_transaction").Register("goal:cache_after_create", Cache)
+	db.Callback().Create().After("goal:cache_after_create").Register("goal:forget_not_found", forgetNotFound)
+	db.Callback().Update().Before("gorm:before_update").Register("goal:cache_collect_update", collectCacheKeys)
+	db.Callback().Update().After("gorm:commit_or_rollback_transaction").Register("goal:cache_after_update", recache)
+	db.Callback().Query().After("gorm:after_query").Register("goal:cache_after_query", Cache)
+	db.Callback().Delete().Before("gorm:before_delete").Register("goal:cache_collect_delete", collectCacheKeys)
+	db.Callback().Delete().After("gorm:commit_or_rollback_transaction").Register("goal:uncache_after_delete", Uncache)
+}
+
+// hasPrimaryKey checks if records of the scope can be cached
+func hasPrimaryKey(scope *gorm.Scope) bool {
+	return scope.Value != nil && scope.PrimaryField() != nil
+}
+
+// inTransaction checks if the scope runs in a transaction started by
+// the caller, which gorm doesn't commit after the statement
+func inTransaction(scope *gorm.Scope) bool {
+	_, ok := scope.SQLDB().(*sql.Tx)
+	return ok
+}
+
+// collectCacheKeys records cache keys of the rows an update or delete
+// is about to change. A statement without primary key, like
+// db.Where("age > ?", 20).Delete(&user{}), may change many rows, their
+// ids are selected with the same conditions
+func collectCacheKeys(scope *gorm.Scope) {
+	if SharedCache == nil || scope.HasError() || !hasPrimaryKey(scope) {
+		return
+	}
+
+	if !scope.PrimaryKeyZero() {
+		scope.InstanceSet(cacheKeysInstanceKey, []string{cacheKeyFromScope(scope)})
+		return
+	}
+
+	// Building the conditions appends to SQLVars of the statement
+	vars := scope.SQLVars
+	conditions := scope.CombinedConditionSql()
+	args := append([]interface{}{}, scope.SQLVars[len(vars):]...)
+	scope.SQLVars = vars
+
+	name := scope.TableName()
+	sql := fmt.Sprintf("SELECT %v.%v FROM %v %v",
+		scope.QuotedTableName(), scope.Quote(scope.PrimaryKey()), scope.QuotedTableName(), conditions)
+
+	rows, err := scope.NewDB().Raw(sql, args...).Rows()
+	if err != nil {
+		scope.Err(err)
+		return
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var id interface{}
+		err = rows.Scan(&id)
+		if err != nil {
+			scope.Err(err)
+			return
+		}
+
+		if data, ok := id.([]byte); ok {
+			id = string(data)
+		}
+		keys = append(keys, DefaultCacheKey(name, id))
+	}
+
+	scope.InstanceSet(cacheKeysInstanceKey, keys)
+}
+
+// collectedCacheKeys returns keys recorded by collectCacheKeys
+func collectedCacheKeys(scope *gorm.Scope) []string {
+	keys, _ := scope.InstanceGet(cacheKeysInstanceKey)
+	collected, _ := keys.([]string)
+	return collected
+}
+
+// Uncache deletes records changed by the scope from cacher
+func Uncache(scope *gorm.Scope) {
+	if SharedCache == nil || scope.HasError() {
+		return
+	}
+
+	keys := collectedCacheKeys(scope)
+	if len(keys) > 0 {
+		err := deleteMulti(scopeContext(scope), SharedCache, keys)
+		reportCacheError("delete", strings.Join(keys, " "), err)
+	}
+}
+
+// recache refreshes records changed by an update. The value of an
+// update may only hold the changed fields, so a single record is
+// reloaded from database, and deleted from cacher if that fails. Rows
+// of a bulk update are deleted
+func recache(scope *gorm.Scope) {
+	if SharedCache == nil || scope.HasError() {
+		return
+	}
+
+	if scope.PrimaryKeyZero() || cacheOptions(scope.Value).Disabled || inTransaction(scope) {
+		Uncache(scope)
+		return
+	}
+
+	ctx := scopeContext(scope)
+	key := cacheKeyFromScope(scope)
+	record := reflect.New(scope.GetModelStruct().ModelType).Interface()
+
+	err := scope.NewDB().Set(skipCacheSettingKey, true).First(record, scope.PrimaryKeyValue()).Error
+	if err == nil {
+		err = cacheResource(ctx, key, record)
+		if err == nil {
+			return
+		}
+		reportCacheError("set", key, err)
+	}
+
+	reportCacheError("delete", key, SharedCache.Delete(ctx, key))
+}
+
+// Cache stores created or found records in cacher. Every record of a
+// query is cached, except records without primary key, rows of
+// queries selecting only some columns and records of a transaction
+// that is not committed yet
+func Cache(scope *gorm.Scope) {
+	if SharedCache == nil || scope.HasError() || !hasPrimaryKey(scope) || inTransaction(scope) {
+		return
+	}
+
+	if skip, ok := scope.Get(skipCacheSettingKey); ok && skip == true {
+		return
+	}
+
+	if cacheOptions(scope.Value).Disabled {
+		return
+	}
+
+	if selectsColumns(scope) {
+		return
+	}
+
+	ctx := scopeContext(scope)
+	value := reflect.Indirect(reflect.ValueOf(scope.Value))
+	if value.Kind() != reflect.Slice {
+		cacheRecord(ctx, scope, scope.Value)
+		return
+	}
+
+	// Rows of a query are stored in one batch
+	var keys []string
+	var records []interface{}
+	for i := 0; i < value.Len(); i++ {
+		item := value.Index(i)
+		if item.Kind() != reflect.Ptr {
+			item = item.Addr()
+		}
+
+		recordScope := scope.New(item.Interface())
+		if !recordScope.PrimaryKeyZero() {
+			keys = append(keys, cacheKeyFromScope(recordScope))
+			records = append(records, item.Interface())
+		}
+	}
+
+	if len(keys) > 0 {
+		err := setMulti(ctx, SharedCache, keys, records, cacheOptions(records[0]).TTL)
+		reportCacheError("set", strings.Join(keys, " "), err)
+	}
+}
+
+// cacheRecord stores a record unless its primary key is zero
+func cacheRecord(ctx context.Context, scope *gorm.Scope, record interface{}) {
+	recordScope := scope.New(record)
+	if recordScope.PrimaryKeyZero() {
+		return
+	}
+
+	key := cacheKeyFromScope(recordScope)
+	reportCacheError("set", key, cacheResource(ctx, key, record))
+}
+
+// selectsColumns checks if the query selects only some columns, like
+// db.Select("name").Find(&users). Such rows are partial
+func selectsColumns(scope *gorm.Scope) bool {
+	return len(scope.SelectAttrs()) > 0
+}
@@ -0,0 +1,174 @@
+package goal_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/thomasdao/goal"
+)
+
+// readUser reads a testuser through the API
+func readUser(t *testing.T, id interface{}) (int, *testuser) {
+	res, err := http.Get(idURL(id))
+	if err != nil {
+		t.Error(err)
+		return 0, nil
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return res.StatusCode, nil
+	}
+
+	var user testuser
+	json.NewDecoder(res.Body).Decode(&user)
+	return res.StatusCode, &user
+}
+
+func TestCachePartialUpdate(t *testing.T) {
+	setup()
+	defer tearDown()
+
+	user := &testuser{Name: "Thomas", Age: 28}
+	db.Create(user)
+
+	// The value of this update only has the primary key
+	db.Model(&testuser{ID: user.ID}).Update("name", "Thomas Dao")
+
+	var cached testuser
+	err := goal.SharedCache.Get(context.Background(), goal.CacheKey(user), &cached)
+	if err != nil || cached.Name != "Thomas Dao" || cached.Age != 28 {
+		t.Error("Updated record should be reloaded into cache", err, cached)
+	}
+
+	_, found := readUser(t, user.ID)
+	if found == nil || found.Name != "Thomas Dao" || found.Age != 28 {
+		t.Error("Read should return the full updated record", found)
+	}
+}
+
+func TestCacheBulkChanges(t *testing.T) {
+	setup()
+	defer tearDown()
+
+	users := []*testuser{{Name: "A", Age: 21}, {Name: "B", Age: 22}, {Name: "C", Age: 18}}
+	for _, user := range users {
+		db.Create(user)
+
+		// Fill the cache
+		readUser(t, user.ID)
+	}
+
+	db.Model(&testuser{}).Where("age > ?", 20).Update("name", "Adult")
+
+	for _, user := range users {
+		_, found := readUser(t, user.ID)
+		expected := user.Name
+		if user.Age > 20 {
+			expected = "Adult"
+		}
+
+		if found == nil || found.Name != expected {
+			t.Error("Bulk update should invalidate cached records", user.ID, found)
+		}
+	}
+
+	db.Where("age > ?", 20).Delete(&testuser{})
+
+	for _, user := range users {
+		code, _ := readUser(t, user.ID)
+		expected := 200
+		if user.Age > 20 {
+			expected = 404
+		}
+
+		if code != expected {
+			t.Error("Bulk delete should invalidate cached records", user.ID, code)
+		}
+	}
+}
+
+func TestCachePartialSelect(t *testing.T) {
+	setup()
+	defer tearDown()
+
+	user := &testuser{Name: "Thomas", Age: 28}
+	db.Create(user)
+
+	key := goal.CacheKey(user)
+	goal.SharedCache.Delete(context.Background(), key)
+
+	var partial testuser
+	db.Select("id, name").First(&partial, user.ID)
+
+	if exists, _ := goal.SharedCache.Exists(context.Background(), key); exists {
+		t.Error("Rows with some columns selected should not be cached")
+	}
+
+	db.Select([]string{"id", "name"}).Find(&[]testuser{})
+	if exists, _ := goal.SharedCache.Exists(context.Background(), key); exists {
+		t.Error("Rows with a list of columns selected should not be cached")
+	}
+
+	var found []testuser
+	db.Find(&found)
+
+	if exists, _ := goal.SharedCache.Exists(context.Background(), key); !exists {
+		t.Error("Every row of a query should be cached")
+	}
+}
+
+type failingCacher struct{}
+
+var errCacheDown = errors.New("cache is down")
+
+func (cache failingCacher) Get(ctx context.Context, key string, val interface{}) error {
+	return errCacheDown
+}
+
+func (cache failingCacher) Set(ctx context.Context, key string, val interface{}) error {
+	return errCacheDown
+}
+
+func (cache failingCacher) Delete(ctx context.Context, key string) error {
+	return errCacheDown
+}
+
+func (cache failingCacher) Exists(ctx context.Context, key string) (bool, error) {
+	return false, errCacheDown
+}
+
+func TestCacheErrorHandler(t *testing.T) {
+	setup()
+	defer tearDown()
+
+	var reported []string
+	handler := goal.CacheErrorHandler
+	goal.CacheErrorHandler = func(op string, key string, err error) {
+		reported = append(reported, op+" "+key)
+	}
+	defer func() {
+		goal.CacheErrorHandler = handler
+	}()
+
+	cache := goal.SharedCache
+	goal.RegisterCacher(failingCacher{})
+	defer goal.RegisterCacher(cache)
+
+	user := &testuser{Name: "Thomas"}
+	db.Create(user)
+	db.Delete(user)
+
+	key := goal.CacheKey(user)
+	found := map[string]bool{}
+	for _, failure := range reported {
+		found[failure] = true
+	}
+
+	if !found["set "+key] || !found["delete "+key] {
+		t.Error("Cache failures should be reported", reported)
+	}
+}
@@ -0,0 +1,77 @@
+package goal
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// CacheNamespace separates cache keys of apps and environments which
+// share a cache server
+type CacheNamespace struct {
+	App     string
+	Env     string
+	Version string
+}
+
+var cacheNamespace, stateNamespace string
+
+// SetCacheNamespace prefixes every cache key used by goal with the
+// parts of namespace, e.g. "blog:prod:v2:post:1". Empty parts keep
+// their place, e.g. "blog::v2:post:1", so they can't collide. Changing
+// Version abandons all entries cached by older versions at once.
+// Session invalidation, failed login attempts and role generation are
+// prefixed by App and Env only, so they survive a Version change
+func SetCacheNamespace(namespace CacheNamespace) {
+	cacheNamespace = joinNamespace(namespace.App, namespace.Env, namespace.Version)
+	stateNamespace = joinNamespace(namespace.App, namespace.Env)
+}
+
+// joinNamespace returns prefix of the parts, empty if every part is
+func joinNamespace(parts ...string) string {
+	for _, part := range parts {
+		if part != "" {
+			return strings.Join(parts, ":") + ":"
+		}
+	}
+
+	return ""
+}
+
+// CacheKeyPrefix returns the prefix of cache keys, empty if no
+// namespace is set
+func CacheKeyPrefix() string {
+	return cacheNamespace
+}
+
+func namespacedKey(key string) string {
+	return cacheNamespace + key
+}
+
+// stateKey prefixes a key of internal state, which is not versioned
+func stateKey(key string) string {
+	return stateNamespace + key
+}
+
+// PrefixClearer is implemented by cachers which can delete every key
+// with a prefix. Keys of internal state, like session invalidation and
+// failed login attempts, are kept
+type PrefixClearer interface {
+	ClearPrefix(ctx context.Context, prefix string) error
+}
+
+// ClearCache deletes keys of the cache namespace from SharedCache.
+// Without a namespace every key of the cacher is deleted, except keys
+// of internal state, so revoked sessions and login lockouts stay
+func ClearCache(ctx context.Context) error {
+	if SharedCache == nil {
+		return nil
+	}
+
+	clearer, ok := SharedCache.(PrefixClearer)
+	if !ok {
+		return errors.New("cacher can't clear keys by prefix")
+	}
+
+	return clearer.ClearPrefix(ctx, cacheNamespace)
+}
@@ -0,0 +1,160 @@
+package goal_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/thomasdao/goal"
+)
+
+type ledger struct {
+	ID     uint `gorm:"primary_key"`
+	Amount int
+}
+
+func (l *ledger) CacheOptions() goal.CacheOptions {
+	return goal.CacheOptions{Disabled: true}
+}
+
+func (l *ledger) Get(w http.ResponseWriter, request *http.Request) (int, interface{}, error) {
+	return goal.Read(reflect.TypeOf(l), request)
+}
+
+func TestCacheNamespace(t *testing.T) {
+	setup()
+	defer tearDown()
+
+	previous := goal.SharedCache
+	cache := goal.NewMemoryCache(0, 0)
+	goal.RegisterCacher(cache)
+	defer goal.RegisterCacher(previous)
+
+	goal.SetCacheNamespace(goal.CacheNamespace{App: "blog", Env: "test", Version: "v1"})
+	defer goal.SetCacheNamespace(goal.CacheNamespace{})
+
+	user := &testuser{Name: "Namespaced"}
+	db.Create(user)
+
+	key := goal.CacheKey(user)
+	if key != fmt.Sprint("blog:test:v1:testuser:", user.ID) {
+		t.Error("Cache key should start with the namespace", key)
+	}
+
+	ctx := context.Background()
+	if exists, _ := cache.Exists(ctx, key); !exists {
+		t.Error("Record should be cached in the namespace")
+	}
+
+	// Keys of other namespaces survive ClearCache
+	cache.Set(ctx, "blog:prod:v1:testuser:1", user)
+	if err := goal.ClearCache(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if exists, _ := cache.Exists(ctx, key); exists {
+		t.Error("ClearCache should delete keys of the namespace")
+	}
+
+	if exists, _ := cache.Exists(ctx, "blog:prod:v1:testuser:1"); !exists {
+		t.Error("ClearCache should keep keys of other namespaces")
+	}
+}
+
+func TestCacheDisabledModel(t *testing.T) {
+	setup()
+	defer tearDown()
+
+	previous := goal.SharedCache
+	cache := goal.NewMemoryCache(0, 0)
+	goal.RegisterCacher(cache)
+	defer goal.RegisterCacher(previous)
+
+	goal.RegisterModel(&ledger{})
+
+	entry := &ledger{Amount: 10}
+	db.Create(entry)
+
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", fmt.Sprint("/ledger/", entry.ID), nil)
+	goal.SharedAPI().Mux().ServeHTTP(recorder, req)
+	if recorder.Code != 200 {
+		t.Fatal("Record should be read from database", recorder.Code)
+	}
+
+	if exists, _ := cache.Exists(context.Background(), goal.CacheKey(entry)); exists {
+		t.Error("Record of a disabled model should not be cached")
+	}
+
+	// Changes are read without waiting for invalidation
+	db.Exec("UPDATE ledger SET amount = 20 WHERE id = ?", entry.ID)
+	recorder = httptest.NewRecorder()
+	goal.SharedAPI().Mux().ServeHTTP(recorder, req)
+	if recorder.Code != 200 || !strings.Contains(recorder.Body.String(), `"Amount":20`) {
+		t.Error("Record should be read from database again", recorder.Body.String())
+	}
+}
+
+func TestCacheNamespaceKeepsRevokedSessions(t *testing.T) {
+	setup()
+	defer tearDown()
+
+	previous := goal.SharedCache
+	goal.RegisterCacher(goal.NewMemoryCache(0, 0))
+	defer goal.RegisterCacher(previous)
+
+	goal.SetCacheNamespace(goal.CacheNamespace{App: "blog", Env: "test", Version: "v1"})
+	defer goal.SetCacheNamespace(goal.CacheNamespace{})
+
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/auth/register", strings.NewReader(`{"username":"revokeme", "password": "secret-password"}`))
+	goal.SharedAPI().Mux().ServeHTTP(recorder, req)
+	cookie := recorder.Header().Get("Set-Cookie")
+
+	var user testuser
+	db.Where("username = ?", "revokeme").First(&user)
+	if err := goal.InvalidateUserSessions(&user); err != nil {
+		t.Fatal(err)
+	}
+
+	revoked := func() bool {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Add("Cookie", cookie)
+		_, err := goal.GetCurrentUser(req)
+		return err != nil
+	}
+
+	if err := goal.ClearCache(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if !revoked() {
+		t.Error("Revoked session should stay revoked after ClearCache")
+	}
+
+	goal.SetCacheNamespace(goal.CacheNamespace{App: "blog", Env: "test", Version: "v2"})
+	if !revoked() {
+		t.Error("Revoked session should stay revoked after a version bump")
+	}
+}
+
+func TestCacheNamespaceEmptyParts(t *testing.T) {
+	defer goal.SetCacheNamespace(goal.CacheNamespace{})
+
+	goal.SetCacheNamespace(goal.CacheNamespace{App: "blog", Version: "v1"})
+	versioned := goal.CacheKeyPrefix()
+
+	goal.SetCacheNamespace(goal.CacheNamespace{App: "blog", Env: "v1"})
+	if versioned == goal.CacheKeyPrefix() || versioned != "blog::v1:" {
+		t.Error("Empty parts should keep their place", versioned, goal.CacheKeyPrefix())
+	}
+
+	goal.SetCacheNamespace(goal.CacheNamespace{})
+	if goal.CacheKeyPrefix() != "" {
+		t.Error("Empty namespace should have no prefix", goal.CacheKeyPrefix())
+	}
+}
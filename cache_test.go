@@ -0,0 +1,126 @@
+package goal_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/thomasdao/goal"
+)
+
+// bookmark is cached with sliding expiry
+type bookmark struct {
+	ID  uint `gorm:"primary_key"`
+	URL string
+}
+
+func (b *bookmark) CacheOptions() goal.CacheOptions {
+	return goal.CacheOptions{TTL: 200 * time.Millisecond, Sliding: true}
+}
+
+func (b *bookmark) Get(w http.ResponseWriter, request *http.Request) (int, interface{}, error) {
+	return goal.Read(reflect.TypeOf(b), request)
+}
+
+// ttlRecorder remembers expiry requested for each key
+type ttlRecorder struct {
+	*goal.MemoryCache
+
+	mutex   sync.Mutex
+	set     map[string]time.Duration
+	touched map[string]time.Duration
+}
+
+func newTTLRecorder() *ttlRecorder {
+	return &ttlRecorder{
+		MemoryCache: goal.NewMemoryCache(0, 0),
+		set:         make(map[string]time.Duration),
+		touched:     make(map[string]time.Duration),
+	}
+}
+
+func (cache *ttlRecorder) SetWithTTL(ctx context.Context, key string, val interface{}, ttl time.Duration) error {
+	cache.mutex.Lock()
+	cache.set[key] = ttl
+	cache.mutex.Unlock()
+
+	return cache.MemoryCache.SetWithTTL(ctx, key, val, ttl)
+}
+
+func (cache *ttlRecorder) Touch(ctx context.Context, key string, ttl time.Duration) error {
+	cache.mutex.Lock()
+	cache.touched[key] = ttl
+	cache.mutex.Unlock()
+
+	return cache.MemoryCache.Touch(ctx, key, ttl)
+}
+
+func TestModelCacheTTL(t *testing.T) {
+	setup()
+	defer tearDown()
+
+	previous := goal.SharedCache
+	cache := newTTLRecorder()
+	goal.RegisterCacher(cache)
+	defer goal.RegisterCacher(previous)
+
+	goal.RegisterModel(&bookmark{})
+	created := &bookmark{URL: "https://example.com"}
+	db.Create(created)
+
+	key := goal.CacheKey(created)
+	if ttl := cache.set[key]; ttl != 200*time.Millisecond {
+		t.Fatal("Record should be cached with TTL of the model", ttl)
+	}
+
+	// Reading from cache extends expiry
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", fmt.Sprint("/bookmark/", created.ID), nil)
+	goal.SharedAPI().Mux().ServeHTTP(recorder, req)
+	if recorder.Code != 200 {
+		t.Fatal("Fail to read bookmark", recorder.Body)
+	}
+
+	if ttl := cache.touched[key]; ttl != 200*time.Millisecond {
+		t.Error("Sliding expiry should touch the record", ttl)
+	}
+}
+
+func TestCacheInTransaction(t *testing.T) {
+	setup()
+	defer tearDown()
+
+	goal.RegisterModel(&bookmark{})
+	created := &bookmark{URL: "https://example.com"}
+	db.Create(created)
+	key := goal.CacheKey(created)
+
+	// Rolled back changes never reach the cache
+	tx := db.Begin()
+	tx.Model(created).Update("url", "https://rolled-back.com")
+
+	var found bookmark
+	tx.First(&found, created.ID)
+	tx.Rollback()
+
+	var cached bookmark
+	err := goal.SharedCache.Get(context.Background(), key, &cached)
+	if err == nil && cached.URL != "https://example.com" {
+		t.Error("Uncommitted record should not be cached", cached.URL)
+	}
+
+	tx = db.Begin()
+	inserted := &bookmark{URL: "https://inserted.com"}
+	tx.Create(inserted)
+	tx.Rollback()
+
+	err = goal.SharedCache.Get(context.Background(), goal.CacheKey(inserted), &cached)
+	if err == nil {
+		t.Error("Record created in rolled back transaction should not be cached", cached)
+	}
+}
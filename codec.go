@@ -0,0 +1,233 @@
+package goal
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+)
+
+// Codec serializes cached values. Name is stored with every entry, so
+// entries can be read after the codec of a cache is changed
+type Codec interface {
+	Name() string
+	Marshal(interface{}) ([]byte, error)
+	Unmarshal([]byte, interface{}) error
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) Marshal(val interface{}) ([]byte, error) {
+	return json.Marshal(val)
+}
+
+func (jsonCodec) Unmarshal(data []byte, val interface{}) error {
+	return json.Unmarshal(data, val)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Name() string {
+	return "gob"
+}
+
+func (gobCodec) Marshal(val interface{}) ([]byte, error) {
+	var buffer bytes.Buffer
+	err := gob.NewEncoder(&buffer).Encode(val)
+	return buffer.Bytes(), err
+}
+
+func (gobCodec) Unmarshal(data []byte, val interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(val)
+}
+
+// Built in codecs. GobCodec is fastest for Go only consumers, but
+// ignores json tags. MsgpackCodec produces smaller entries than
+// JSONCodec with the same field names
+var (
+	JSONCodec    Codec = jsonCodec{}
+	GobCodec     Codec = gobCodec{}
+	MsgpackCodec Codec = msgpackCodec{}
+)
+
+var (
+	codecsMutex sync.RWMutex
+	codecs      = map[string]Codec{}
+)
+
+// RegisterCodec makes a codec available to decode cached entries
+func RegisterCodec(codec Codec) {
+	codecsMutex.Lock()
+	defer codecsMutex.Unlock()
+
+	codecs[codec.Name()] = codec
+}
+
+func codecByName(name string) Codec {
+	codecsMutex.RLock()
+	defer codecsMutex.RUnlock()
+
+	return codecs[name]
+}
+
+func init() {
+	RegisterCodec(JSONCodec)
+	RegisterCodec(GobCodec)
+	RegisterCodec(MsgpackCodec)
+}
+
+// Errors of decoding a cached entry, caches treat both as misses
+var (
+	ErrInvalidEntry   = errors.New("invalid cache entry")
+	ErrSchemaMismatch = errors.New("cache entry has another schema version")
+)
+
+// Entries start with a magic number and format version, then flags,
+// codec name, schema version of the value, and the payload
+var envelopeMagic = []byte{'g', 'l', 1}
+
+const envelopeGzip byte = 1
+
+// CacheEncoding serializes values of a cache into versioned entries
+type CacheEncoding struct {
+	// Codec defaults to JSONCodec
+	Codec Codec
+
+	// CompressAbove gzips payloads larger than this number of bytes,
+	// zero disables compression
+	CompressAbove int
+}
+
+func (encoding CacheEncoding) codec() Codec {
+	if encoding.Codec != nil {
+		return encoding.Codec
+	}
+
+	return JSONCodec
+}
+
+// Marshal encodes the value into an entry
+func (encoding CacheEncoding) Marshal(val interface{}) ([]byte, error) {
+	codec := encoding.codec()
+	payload, err := codec.Marshal(val)
+	if err != nil {
+		return nil, err
+	}
+
+	var flags byte
+	if encoding.CompressAbove > 0 && len(payload) > encoding.CompressAbove {
+		var compressed bytes.Buffer
+		writer := gzip.NewWriter(&compressed)
+		_, err = writer.Write(payload)
+		if err == nil {
+			err = writer.Close()
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		payload = compressed.Bytes()
+		flags |= envelopeGzip
+	}
+
+	name := codec.Name()
+	if len(name) > 255 {
+		return nil, fmt.Errorf("codec name %s is too long", name)
+	}
+
+	entry := make([]byte, 0, len(envelopeMagic)+2+len(name)+binary.MaxVarintLen64+len(payload))
+	entry = append(entry, envelopeMagic...)
+	entry = append(entry, flags, byte(len(name)))
+	entry = append(entry, name...)
+
+	var version [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(version[:], int64(schemaVersion(val)))
+	entry = append(entry, version[:n]...)
+
+	return append(entry, payload...), nil
+}
+
+// Unmarshal decodes an entry into the value. It returns
+// ErrSchemaMismatch if the entry was written with another schema
+// version of the value, and ErrInvalidEntry if it can't be decoded.
+// Entries without envelope were written as plain json by older
+// versions, they are decoded as schema version zero
+func (encoding CacheEncoding) Unmarshal(entry []byte, val interface{}) error {
+	if !bytes.HasPrefix(entry, envelopeMagic) {
+		return unmarshalLegacy(entry, val)
+	}
+
+	if len(entry) < len(envelopeMagic)+2 {
+		return ErrInvalidEntry
+	}
+
+	entry = entry[len(envelopeMagic):]
+	flags, nameLength := entry[0], int(entry[1])
+	entry = entry[2:]
+	if len(entry) < nameLength {
+		return ErrInvalidEntry
+	}
+
+	codec := codecByName(string(entry[:nameLength]))
+	if codec == nil {
+		return ErrInvalidEntry
+	}
+	entry = entry[nameLength:]
+
+	version, n := binary.Varint(entry)
+	if n <= 0 {
+		return ErrInvalidEntry
+	}
+
+	if version != int64(schemaVersion(val)) {
+		return ErrSchemaMismatch
+	}
+
+	payload := entry[n:]
+	if flags&envelopeGzip != 0 {
+		reader, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return ErrInvalidEntry
+		}
+
+		payload, err = ioutil.ReadAll(reader)
+		if err != nil {
+			return ErrInvalidEntry
+		}
+	}
+
+	if codec.Unmarshal(payload, val) != nil {
+		return ErrInvalidEntry
+	}
+
+	return nil
+}
+
+// unmarshalLegacy decodes an entry written before entries had an
+// envelope
+func unmarshalLegacy(entry []byte, val interface{}) error {
+	if schemaVersion(val) != 0 {
+		return ErrSchemaMismatch
+	}
+
+	if json.Unmarshal(entry, val) != nil {
+		return ErrInvalidEntry
+	}
+
+	return nil
+}
+
+// schemaVersion returns CacheOptions.Version of the model of a value,
+// which may also be a slice of records
+func schemaVersion(val interface{}) int {
+	return cacheOptions(val).Version
+}
@@ -0,0 +1,235 @@
+package goal_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/thomasdao/goal"
+)
+
+type noteV1 struct {
+	ID   uint
+	Text string
+}
+
+func (n *noteV1) CacheOptions() goal.CacheOptions {
+	return goal.CacheOptions{Version: 1}
+}
+
+type noteV2 struct {
+	ID   uint
+	Text string
+}
+
+func (n *noteV2) CacheOptions() goal.CacheOptions {
+	return goal.CacheOptions{Version: 2}
+}
+
+func TestCacheEncoding(t *testing.T) {
+	user := &testuser{ID: 1, Name: "Thomas", Age: 28, Rev: -3}
+	users := []testuser{*user, {ID: 2, Name: strings.Repeat("Dao", 100)}}
+
+	for _, codec := range []goal.Codec{goal.JSONCodec, goal.GobCodec, goal.MsgpackCodec} {
+		for _, compress := range []int{0, 10} {
+			encoding := goal.CacheEncoding{Codec: codec, CompressAbove: compress}
+
+			data, err := encoding.Marshal(user)
+			if err != nil {
+				t.Error(codec.Name(), err)
+				continue
+			}
+
+			var decoded testuser
+			err = encoding.Unmarshal(data, &decoded)
+			if err != nil || decoded != *user {
+				t.Error("Value should survive round trip", codec.Name(), compress, decoded, err)
+			}
+
+			data, _ = encoding.Marshal(users)
+			var decodedUsers []testuser
+			err = encoding.Unmarshal(data, &decodedUsers)
+			if err != nil || !reflect.DeepEqual(decodedUsers, users) {
+				t.Error("Slice should survive round trip", codec.Name(), compress, err)
+			}
+
+			// Entries name their codec, so any encoding reads them
+			err = goal.CacheEncoding{}.Unmarshal(data, &decodedUsers)
+			if err != nil || !reflect.DeepEqual(decodedUsers, users) {
+				t.Error("Entry should be readable after codec changes", codec.Name(), err)
+			}
+		}
+	}
+}
+
+func TestCacheEncodingCompression(t *testing.T) {
+	value := strings.Repeat("goal ", 1000)
+	plain, _ := goal.CacheEncoding{}.Marshal(value)
+	compressed, _ := goal.CacheEncoding{CompressAbove: 100}.Marshal(value)
+
+	if len(compressed) >= len(plain)/10 {
+		t.Error("Large value should be compressed", len(plain), len(compressed))
+	}
+}
+
+func TestCacheEncodingErrors(t *testing.T) {
+	encoding := goal.CacheEncoding{}
+
+	data, _ := encoding.Marshal(&noteV1{ID: 1, Text: "Old"})
+	var note noteV2
+	if err := encoding.Unmarshal(data, &note); err != goal.ErrSchemaMismatch {
+		t.Error("Entry of another schema version should be detected", err)
+	}
+
+	var slice []noteV2
+	data, _ = encoding.Marshal([]noteV1{{ID: 1}})
+	if err := encoding.Unmarshal(data, &slice); err != goal.ErrSchemaMismatch {
+		t.Error("Schema version of slices should be checked", err)
+	}
+
+	var user testuser
+	data, _ = encoding.Marshal(&testuser{Name: "Thomas"})
+	for _, entry := range [][]byte{[]byte(`{"Name":`), []byte("gl"), data[:len(data)-2]} {
+		if err := encoding.Unmarshal(entry, &user); err != goal.ErrInvalidEntry {
+			t.Error("Invalid entry should be detected", string(entry), err)
+		}
+	}
+}
+
+func TestCacheEncodingLegacy(t *testing.T) {
+	encoding := goal.CacheEncoding{}
+
+	// Plain json written before entries had an envelope
+	var user testuser
+	if err := encoding.Unmarshal([]byte(`{"Name":"Thomas"}`), &user); err != nil || user.Name != "Thomas" {
+		t.Error("Legacy entry should be decoded", user, err)
+	}
+
+	var validAfter int64
+	if err := encoding.Unmarshal([]byte(`1500000000`), &validAfter); err != nil || validAfter != 1500000000 {
+		t.Error("Legacy number should be decoded", validAfter, err)
+	}
+
+	// Models with a schema version never had legacy entries
+	var note noteV2
+	if err := encoding.Unmarshal([]byte(`{"ID":1}`), &note); err != goal.ErrSchemaMismatch {
+		t.Error("Legacy entry of a versioned model should be a mismatch", err)
+	}
+}
+
+func TestMsgpackCodec(t *testing.T) {
+	data, err := goal.MsgpackCodec.Marshal(map[string]interface{}{"a": 1, "b": []interface{}{true, nil, -1, 1.5, "x"}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected := []byte{0x82, 0xa1, 'a', 0x01, 0xa1, 'b', 0x95, 0xc3, 0xc0, 0xff,
+		0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0, 0xa1, 'x'}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("Invalid MessagePack % x", data)
+	}
+
+	var value struct {
+		A int
+		B []interface{}
+	}
+	err = goal.MsgpackCodec.Unmarshal(data, &value)
+	if err != nil || value.A != 1 || len(value.B) != 5 || value.B[3] != 1.5 {
+		t.Error("MessagePack should be decoded", value, err)
+	}
+}
+
+func TestMsgpackRoundTrip(t *testing.T) {
+	many := make([]interface{}, 70000)
+	for i := range many {
+		many[i] = i
+	}
+
+	keys := make(map[string]interface{})
+	for i := 0; i < 20; i++ {
+		keys[strings.Repeat("k", i+1)] = i
+	}
+
+	values := []interface{}{
+		nil, true, false, 0, 127, 128, 255, 256, 65535, 65536, -1, -32, -33, -128, -129,
+		-32768, -32769, math.MaxInt32, math.MinInt32, int64(math.MaxInt64), int64(math.MinInt64),
+		uint64(math.MaxUint64), 1.5, -0.25, 1e300, "", strings.Repeat("x", 31),
+		strings.Repeat("x", 32), strings.Repeat("x", 256), strings.Repeat("x", 70000), "日本",
+		[]interface{}{}, many[:15], many[:16], many, keys,
+		map[string]interface{}{"nested": []interface{}{map[string]interface{}{"a": []interface{}{1, "b"}}}},
+	}
+
+	for _, value := range values {
+		data, err := goal.MsgpackCodec.Marshal(value)
+		if err != nil {
+			t.Error("Fail to encode", err)
+			continue
+		}
+
+		// Decoded into the same type, numbers would be float64 in
+		// interface{} like with JSONCodec
+		var decoded interface{} = new(interface{})
+		if value != nil {
+			decoded = reflect.New(reflect.TypeOf(value)).Interface()
+		}
+
+		err = goal.MsgpackCodec.Unmarshal(data, decoded)
+		expected, _ := json.Marshal(value)
+		actual, _ := json.Marshal(decoded)
+		if err != nil || !bytes.Equal(expected, actual) {
+			t.Errorf("Value should survive round trip %.50s %.50s %v", expected, actual, err)
+		}
+	}
+}
+
+func TestMsgpackDepth(t *testing.T) {
+	// Arrays nested deeper than encoding/json allows are rejected
+	// while reading, before they are converted to json
+	data := append(bytes.Repeat([]byte{0x91}, 100000), 0xc0)
+
+	var decoded interface{}
+	err := goal.MsgpackCodec.Unmarshal(data, &decoded)
+	if err == nil || !strings.HasPrefix(err.Error(), "msgpack:") {
+		t.Error("Deeply nested data should be rejected", err)
+	}
+
+	data = append(bytes.Repeat([]byte{0x81, 0xa1, 'a'}, 100), 0xc0)
+	if err := goal.MsgpackCodec.Unmarshal(data, &decoded); err != nil {
+		t.Error("Nested maps should be decoded", err)
+	}
+}
+
+func FuzzMsgpackCodec(f *testing.F) {
+	for _, value := range []interface{}{
+		nil, 1, -200, 1.5, "goal", []interface{}{1, "a"},
+		map[string]interface{}{"a": []interface{}{true, nil}},
+	} {
+		data, _ := goal.MsgpackCodec.Marshal(value)
+		f.Add(data)
+	}
+	f.Add([]byte{0xdd, 0xff, 0xff, 0xff, 0xff})
+	f.Add([]byte{0xdb, 0xff, 0xff, 0xff, 0xff})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var decoded interface{}
+		if goal.MsgpackCodec.Unmarshal(data, &decoded) != nil {
+			return
+		}
+
+		// Anything decoded is encoded and decoded to the same value
+		encoded, err := goal.MsgpackCodec.Marshal(decoded)
+		if err != nil {
+			t.Fatal("Decoded value should be encoded", err)
+		}
+
+		var again interface{}
+		err = goal.MsgpackCodec.Unmarshal(encoded, &again)
+		if err != nil || !reflect.DeepEqual(decoded, again) {
+			t.Fatal("Value should survive round trip", decoded, again, err)
+		}
+	})
+}
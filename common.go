@@ -36,29 +36,57 @@ func getErrorString(data interface{}, err error) string {
 	return string(errByte)
 }
 
-// Write response back to client
-func renderJSON(rw http.ResponseWriter, request *http.Request, handler simpleResponse) {
-	if handler == nil {
-		http.Error(rw, http.ErrNotSupported.Error(), http.StatusMethodNotAllowed)
-		return
+// Run the handler through middlewares of the API and write
+// response back to client
+func (api *API) renderJSON(
+	rw http.ResponseWriter, request *http.Request,
+	resource interface{}, operation Operation, handler simpleResponse) {
+	request = withAPI(request, api)
+	auditMasterKey(request)
+
+	// Current user is loaded at most once per request
+	request = withCurrentUser(request)
+
+	call := &Call{
+		Writer:    rw,
+		Request:   request,
+		Resource:  resource,
+		Operation: operation,
 	}
 
-	code, data, err := handler(rw, request)
+	api.chain(handler)(call)
 
-	if err != nil {
-		http.Error(rw, getErrorString(data, err), code)
+	if call.Code == 0 {
+		call.Code = http.StatusOK
+		if call.Err != nil {
+			call.Code = http.StatusInternalServerError
+		}
+	}
+
+	if call.Err != nil {
+		writeJSON(rw, call.Code, []byte(getErrorString(call.Result, call.Err)))
 		return
 	}
 
-	var content []byte
-	content, err = json.Marshal(data)
+	if call.Code == http.StatusNoContent {
+		rw.WriteHeader(call.Code)
+		return
+	}
+
+	content, err := json.Marshal(call.Result)
 	if err != nil {
-		http.Error(rw, getErrorString(nil, err), http.StatusInternalServerError)
+		writeJSON(rw, http.StatusInternalServerError, []byte(getErrorString(nil, err)))
 		return
 	}
 
-	rw.WriteHeader(code)
+	writeJSON(rw, call.Code, content)
+}
+
+// writeJSON writes headers before the status code, headers set
+// after WriteHeader are ignored
+func writeJSON(rw http.ResponseWriter, code int, content []byte) {
 	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(code)
 	rw.Write(content)
 }
 
@@ -29,6 +29,48 @@ type article struct {
 	goal.Permission
 }
 
+type note struct {
+	ID   uint `gorm:"primary_key"`
+	Text string
+	goal.ACLPermission
+}
+
+type employee struct {
+	ID     uint `gorm:"primary_key"`
+	Name   string
+	Salary int
+	Status string
+}
+
+type post struct {
+	ID       uint `gorm:"primary_key"`
+	AuthorID uint
+	Title    string
+}
+
+type task struct {
+	ID       uint `gorm:"primary_key"`
+	Title    string
+	Archived bool
+	Summary  string `sql:"-"`
+}
+
+type comment struct {
+	ID     uint `gorm:"primary_key"`
+	PostID uint
+	Post   *post
+	Text   string
+}
+
+type profile struct {
+	ID     uint    `gorm:"primary_key"`
+	Handle string  `validate:"required,min=3,max=20,unique,regexp=^[a-z0-9_]+$"`
+	Email  string  `json:"email" validate:"required,email"`
+	Plan   string  `validate:"enum=free|pro"`
+	Age    int     `validate:"min=13,max=130"`
+	Code   *string `validate:"len=4"`
+}
+
 var db *gorm.DB
 
 var (
@@ -63,6 +105,9 @@ func setup() {
 	err = redisCache.InitRedisPool(pool)
 	if err == nil {
 		goal.RegisterCacher(redisCache)
+	} else {
+		// Run without Redis
+		goal.RegisterCacher(goal.NewMemoryCache(0, 0))
 	}
 
 	// Initialize API
@@ -70,7 +115,7 @@ func setup() {
 
 	// Initialize resource
 
-	models := []interface{}{&testuser{}, &article{}}
+	models := []interface{}{&testuser{}, &article{}, &note{}, &employee{}, &post{}, &task{}, &profile{}, &comment{}}
 
 	// Add default path
 	for _, model := range models {
@@ -0,0 +1,131 @@
+package goal
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/jinzhu/gorm"
+)
+
+// contextSettingKey stores request context inside gorm settings, so
+// callbacks can pass it to SharedCache
+const contextSettingKey = "goal:context"
+
+// contextDB returns db carrying the context. Queries are not started
+// once the context is canceled or its deadline is exceeded
+func contextDB(ctx context.Context) *gorm.DB {
+	return db.Set(contextSettingKey, ctx)
+}
+
+// scopeContext returns context set by contextDB, or background context
+func scopeContext(scope *gorm.Scope) context.Context {
+	if value, ok := scope.Get(contextSettingKey); ok {
+		if ctx, ok := value.(context.Context); ok && ctx != nil {
+			return ctx
+		}
+	}
+
+	return context.Background()
+}
+
+// checkContext aborts the operation if its context is done
+func checkContext(scope *gorm.Scope) {
+	if err := scopeContext(scope).Err(); err != nil {
+		scope.Err(err)
+	}
+}
+
+// checkRowsContext aborts Pluck and Rows if the context is done.
+// Row and Count have no way to report the error and still run
+func checkRowsContext(scope *gorm.Scope) {
+	err := scopeContext(scope).Err()
+	if err == nil {
+		return
+	}
+
+	if value, ok := scope.InstanceGet("row_query_result"); ok {
+		if result, ok := value.(*gorm.RowsQueryResult); ok {
+			result.Error = err
+			scope.SkipLeft()
+		}
+	}
+}
+
+func registerContextCallbacks() {
+	db.Callback().Create().Before("gorm:begin_transaction").Register("goal:check_context", checkContext)
+	db.Callback().Update().Before("gorm:begin_transaction").Register("goal:check_context", checkContext)
+	db.Callback().Delete().Before("gorm:begin_transaction").Register("goal:check_context", checkContext)
+	db.Callback().Query().Before("gorm:query").Register("goal:check_context", checkContext)
+	db.Callback().RowQuery().Before("gorm:row_query").Register("goal:check_context", checkRowsContext)
+}
+
+type contextKey int
+
+const (
+	currentUserContextKey contextKey = iota
+	requestIDContextKey
+	apiContextKey
+)
+
+// requestContext returns context of the request, or background
+// context without a request
+func requestContext(request *http.Request) context.Context {
+	if request == nil {
+		return context.Background()
+	}
+
+	return request.Context()
+}
+
+// withAPI remembers the API handling the request
+func withAPI(request *http.Request, api *API) *http.Request {
+	ctx := context.WithValue(request.Context(), apiContextKey, api)
+	return request.WithContext(ctx)
+}
+
+// apiOf returns the API handling the request, or the shared API
+// outside of API handlers
+func apiOf(request *http.Request) *API {
+	if api, ok := request.Context().Value(apiContextKey).(*API); ok && api != nil {
+		return api
+	}
+
+	return sharedAPI
+}
+
+// currentUser memoizes the result of GetCurrentUser for a request
+type currentUser struct {
+	mutex    sync.Mutex
+	resolved bool
+	user     interface{}
+	err      error
+}
+
+// withCurrentUser prepares the request to memoize current user, so
+// it is loaded at most once per request
+func withCurrentUser(request *http.Request) *http.Request {
+	if currentUserOf(request) != nil {
+		return request
+	}
+
+	ctx := context.WithValue(request.Context(), currentUserContextKey, &currentUser{})
+	return request.WithContext(ctx)
+}
+
+func currentUserOf(request *http.Request) *currentUser {
+	entry, _ := request.Context().Value(currentUserContextKey).(*currentUser)
+	return entry
+}
+
+// setCurrentUser replaces memoized user, e.g. after login
+func setCurrentUser(request *http.Request, user interface{}, err error) {
+	entry := currentUserOf(request)
+	if entry == nil {
+		return
+	}
+
+	entry.mutex.Lock()
+	entry.resolved, entry.user, entry.err = true, user, err
+	entry.mutex.Unlock()
+}
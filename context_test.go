@@ -0,0 +1,129 @@
+package goal_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/thomasdao/goal"
+)
+
+// countingCacher keeps values in a map and counts lookups of users
+type countingCacher struct {
+	mutex       sync.Mutex
+	data        map[string][]byte
+	userLookups int
+}
+
+func (cache *countingCacher) Get(ctx context.Context, key string, val interface{}) error {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	data, ok := cache.data[key]
+	if !ok {
+		return errors.New("not found")
+	}
+	return json.Unmarshal(data, val)
+}
+
+func (cache *countingCacher) Set(ctx context.Context, key string, val interface{}) error {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	data, err := json.Marshal(val)
+	cache.data[key] = data
+	return err
+}
+
+func (cache *countingCacher) Delete(ctx context.Context, key string) error {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	delete(cache.data, key)
+	return nil
+}
+
+func (cache *countingCacher) Exists(ctx context.Context, key string) (bool, error) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if strings.HasPrefix(key, "testuser:") {
+		cache.userLookups++
+	}
+	_, ok := cache.data[key]
+	return ok, nil
+}
+
+func TestCurrentUserMemoized(t *testing.T) {
+	setup()
+	defer tearDown()
+
+	send := func(method string, path string, body string, cookie string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		if cookie != "" {
+			req.Header.Add("Cookie", cookie)
+		}
+		goal.SharedAPI().Mux().ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	cookie := send("POST", "/auth/register", `{"username":"author", "password": "secret-password"}`, "").Header().Get("Set-Cookie")
+	for i := 0; i < 3; i++ {
+		send("POST", "/post", `{"Title":"Post"}`, cookie)
+	}
+
+	previous := goal.SharedCache
+	cache := &countingCacher{data: make(map[string][]byte)}
+	goal.RegisterCacher(cache)
+	defer goal.RegisterCacher(previous)
+
+	query := "/query/post/" + url.QueryEscape(`{"where":[],"limit":10}`)
+	res := send("GET", query, "", cookie)
+
+	var results []post
+	json.Unmarshal(res.Body.Bytes(), &results)
+	if len(results) != 3 {
+		t.Fatal("Author should see all posts", res.Body)
+	}
+
+	if cache.userLookups != 1 {
+		t.Error("Current user should be loaded once per request", cache.userLookups)
+	}
+}
+
+func TestCanceledContext(t *testing.T) {
+	setup()
+	defer tearDown()
+
+	user := &testuser{Username: "canceled"}
+	db.Create(user)
+	goal.RedisClearAll()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/testuser/1", nil)
+	goal.SharedAPI().Mux().ServeHTTP(recorder, req.WithContext(ctx))
+	if recorder.Code == 200 {
+		t.Error("Canceled request should not be served", recorder.Body)
+	}
+
+	err := (&goal.RedisCache{}).Set(ctx, "canceled", user)
+	if err != context.Canceled {
+		t.Error("Cacher should honor canceled context", err)
+	}
+
+	goal.EnableRoles()
+	_, err = goal.StoredRoles(ctx, user)
+	if err != context.Canceled {
+		t.Error("Stored roles should honor canceled context", err)
+	}
+}
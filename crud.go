@@ -55,35 +55,42 @@ type PatchSupporter interface {
 func (api *API) crudHandler(resource interface{}) http.HandlerFunc {
 	return func(rw http.ResponseWriter, request *http.Request) {
 		var handler simpleResponse
+		var operation Operation
 
 		switch request.Method {
 		case GET:
+			operation = OperationRead
 			if resource, ok := resource.(GetSupporter); ok {
 				handler = resource.Get
 			}
 		case POST:
+			operation = OperationCreate
 			if resource, ok := resource.(PostSupporter); ok {
 				handler = resource.Post
 			}
 		case PUT:
+			operation = OperationUpdate
 			if resource, ok := resource.(PutSupporter); ok {
 				handler = resource.Put
 			}
 		case DELETE:
+			operation = OperationDelete
 			if resource, ok := resource.(DeleteSupporter); ok {
 				handler = resource.Delete
 			}
 		case HEAD:
+			operation = OperationRead
 			if resource, ok := resource.(HeadSupporter); ok {
 				handler = resource.Head
 			}
 		case PATCH:
+			operation = OperationUpdate
 			if resource, ok := resource.(PatchSupporter); ok {
 				handler = resource.Patch
 			}
 		}
 
-		renderJSON(rw, request, handler)
+		api.renderJSON(rw, request, resource, operation, handler)
 	}
 }
 
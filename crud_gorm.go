@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"reflect"
 
@@ -24,6 +25,7 @@ var db *gorm.DB
 // InitGormDb initializes global variable db
 func InitGormDb(newDb *gorm.DB) {
 	db = newDb
+	registerContextCallbacks()
 }
 
 // DB returns global variable db
@@ -49,43 +51,85 @@ func Read(rType reflect.Type, request *http.Request) (int, interface{}, error) {
 	}
 
 	resource := newObjectWithType(rType)
+	ctx := request.Context()
+	qryDB := contextDB(ctx)
+
+	// Hook may add conditions to the query, cache is skipped then
+	var err error
+	cacheable := SharedCache != nil && !cacheOptions(resource).Disabled
+	if hooker, ok := resource.(BeforeFindHooker); ok {
+		trigger := newTrigger(request, OperationRead, nil, nil)
+		trigger.Query = qryDB
+		err = hooker.HookBeforeFind(trigger)
+		if err != nil {
+			return hookResponse(err)
+		}
+
+		if trigger.Query != qryDB {
+			qryDB = trigger.Query
+			cacheable = false
+		}
+	}
 
 	// Attempt to retrieve from redis first, if not exist, retrieve from
 	// database and cache it
-	var err error
-	if SharedCache != nil {
+	if cacheable {
 		name := TableName(resource)
 		redisKey := DefaultCacheKey(name, id)
-		err = SharedCache.Get(redisKey, resource)
+		err = SharedCache.Get(ctx, redisKey, resource)
 		if err == nil && resource != nil {
-			// Check if resource is authorized
-			err = CanPerform(resource, request, true)
-			if err != nil {
-				return 403, nil, err
-			}
+			touchResource(ctx, redisKey, resource)
+			return readResponse(resource, request)
+		}
 
-			return 200, resource, nil
+		// Record was recently looked up and not found
+		if isNotFound(ctx, name, id) {
+			return 404, nil, gorm.ErrRecordNotFound
 		}
+
+		// Concurrent misses of the same record share one query
+		err = readFlight.load(ctx, redisKey, resource, func(dst interface{}) error {
+			err := qryDB.First(dst, id).Error
+			if err == gorm.ErrRecordNotFound {
+				rememberNotFound(ctx, name, id)
+			} else if err == nil {
+				key := CacheKey(dst)
+				reportCacheError("set", key, cacheResource(ctx, key, dst))
+			}
+			return err
+		})
+	} else {
+		err = qryDB.First(resource, id).Error
+	}
+
+	if err == gorm.ErrRecordNotFound {
+		return 404, nil, err
 	}
 
-	// Retrieve from database
-	err = db.First(resource, id).Error
 	if err != nil {
 		return 500, nil, err
 	}
 
-	// Save to redis
-	if SharedCache != nil {
-		key := CacheKey(resource)
-		SharedCache.Set(key, resource)
-	}
+	return readResponse(resource, request)
+}
 
+// readResponse checks permission and runs after find hook of
+// a record being read
+func readResponse(resource interface{}, request *http.Request) (int, interface{}, error) {
 	// Check if resource is authorized
-	err = CanPerform(resource, request, true)
+	err := CanPerform(resource, request, true)
 	if err != nil {
 		return 403, nil, err
 	}
 
+	if hooker, ok := resource.(AfterFindHooker); ok {
+		err = hooker.HookAfterFind(newTrigger(request, OperationRead, resource, nil))
+		if err != nil {
+			return hookResponse(err)
+		}
+	}
+
+	redactForRequest(resource, request)
 	return 200, resource, nil
 }
 
@@ -99,19 +143,53 @@ func Create(rType reflect.Type, request *http.Request) (int, interface{}, error)
 	resource := newObjectWithType(rType)
 
 	// Parse request body into resource
-	decoder := json.NewDecoder(request.Body)
-	err := decoder.Decode(resource)
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		return 500, nil, err
+	}
+
+	err = json.Unmarshal(body, resource)
 	if err != nil {
 		fmt.Println(err)
 		return 500, nil, err
 	}
 
+	// Reject fields current user is not allowed to write
+	user, _ := GetCurrentUser(request)
+	err = checkWritableFields(resource, body, request, user)
+	if err != nil {
+		return 403, nil, err
+	}
+
+	// Current user owns the record, master requests may set any owner
+	if !IsMasterRequest(request) {
+		setPointerFields(resource, user)
+	}
+
+	trigger := newTrigger(request, OperationCreate, resource, resource)
+	if hooker, ok := resource.(BeforeSaveHooker); ok {
+		err = hooker.HookBeforeSave(trigger)
+		if err != nil {
+			return hookResponse(err)
+		}
+	}
+
+	err = validateRecord(request, trigger, resource)
+	if err != nil {
+		return validationResponse(err)
+	}
+
 	// Save to database
-	err = db.Create(resource).Error
+	err = contextDB(request.Context()).Create(resource).Error
 	if err != nil {
 		return 500, nil, err
 	}
 
+	if hooker, ok := resource.(AfterSaveHooker); ok {
+		logHookError("after save hook:", hooker.HookAfterSave(newTrigger(request, OperationCreate, resource, resource)))
+	}
+
+	redactForRequest(resource, request)
 	return 200, resource, nil
 }
 
@@ -136,16 +214,27 @@ func Update(rType reflect.Type, request *http.Request) (int, interface{}, error)
 
 	// Parse request body into updatedObj
 	updatedObj := newObjectWithType(rType)
-	decoder := json.NewDecoder(request.Body)
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		return 500, nil, err
+	}
 
-	err := decoder.Decode(updatedObj)
+	err = json.Unmarshal(body, updatedObj)
 	if err != nil {
 		fmt.Println(err)
 		return 500, nil, err
 	}
 
+	// Reject fields current user is not allowed to write
+	user, _ := GetCurrentUser(request)
+	err = checkWritableFields(updatedObj, body, request, user)
+	if err != nil {
+		return 403, nil, err
+	}
+
 	// Retrieve from database
-	err = db.First(resource, id).Error
+	ctxDB := contextDB(request.Context())
+	err = ctxDB.First(resource, id).Error
 	if err != nil {
 		fmt.Println(err)
 		return 500, nil, err
@@ -168,19 +257,39 @@ func Update(rType reflect.Type, request *http.Request) (int, interface{}, error)
 
 		if !CanMerge(current, updated) {
 			err = errors.New("conflict")
+			redactForRequest(resource, request)
 			return 409, resource, err
 		}
 
 		updated.SetNextRevision()
 	}
 
+	trigger := newTrigger(request, OperationUpdate, resource, updatedObj)
+	if hooker, ok := resource.(BeforeSaveHooker); ok {
+		err = hooker.HookBeforeSave(trigger)
+		if err != nil {
+			return hookResponse(err)
+		}
+	}
+
+	// Validate the stored record with changes applied
+	err = validateRecord(request, trigger, mergeChanges(resource, updatedObj))
+	if err != nil {
+		return validationResponse(err)
+	}
+
 	// Save to database. Only update fields that is not blank or default values
 	// http://jinzhu.me/gorm/curd.html#update
-	err = db.Model(resource).Update(updatedObj).Error
+	err = ctxDB.Model(resource).Update(updatedObj).Error
 	if err != nil {
 		return 500, nil, err
 	}
 
+	if hooker, ok := resource.(AfterSaveHooker); ok {
+		logHookError("after save hook:", hooker.HookAfterSave(newTrigger(request, OperationUpdate, resource, updatedObj)))
+	}
+
+	redactForRequest(resource, request)
 	return 200, resource, err
 }
 
@@ -204,7 +313,8 @@ func Delete(rType reflect.Type, request *http.Request) (int, interface{}, error)
 	resource := newObjectWithType(rType)
 
 	// Retrieve from database
-	err := db.First(resource, id).Error
+	ctxDB := contextDB(request.Context())
+	err := ctxDB.First(resource, id).Error
 	if err != nil {
 		return 500, nil, err
 	}
@@ -215,11 +325,22 @@ func Delete(rType reflect.Type, request *http.Request) (int, interface{}, error)
 		return 403, nil, err
 	}
 
+	if hooker, ok := resource.(BeforeDeleteHooker); ok {
+		err = hooker.HookBeforeDelete(newTrigger(request, OperationDelete, resource, nil))
+		if err != nil {
+			return hookResponse(err)
+		}
+	}
+
 	// Delete record, if failed show 500 error code
-	err = db.Delete(resource, id).Error
+	err = ctxDB.Delete(resource, id).Error
 	if err != nil {
 		return 500, nil, err
 	}
 
+	if hooker, ok := resource.(AfterDeleteHooker); ok {
+		logHookError("after delete hook:", hooker.HookAfterDelete(newTrigger(request, OperationDelete, resource, nil)))
+	}
+
 	return 200, nil, nil
 }
@@ -2,6 +2,7 @@ package goal_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
@@ -72,7 +73,7 @@ func TestCreate(t *testing.T) {
 	if goal.SharedCache != nil {
 		key := goal.CacheKey(user)
 		var redisUser testuser
-		goal.SharedCache.Get(key, &redisUser)
+		goal.SharedCache.Get(context.Background(), key, &redisUser)
 		if !reflect.DeepEqual(user, redisUser) {
 			t.Error("Incorrect data in redis, ", user, redisUser)
 		}
@@ -128,12 +129,12 @@ func TestGet(t *testing.T) {
 		key := goal.CacheKey(user)
 
 		// Test data exists in Redis
-		if exist, _ := goal.SharedCache.Exists(key); !exist {
+		if exist, _ := goal.SharedCache.Exists(context.Background(), key); !exist {
 			t.Error("Data should be saved into Redis")
 		}
 
 		var redisUser testuser
-		goal.SharedCache.Get(key, &redisUser)
+		goal.SharedCache.Get(context.Background(), key, &redisUser)
 		if !reflect.DeepEqual(user, &redisUser) {
 			t.Error("Incorrect data in redis, ", user, &redisUser)
 		}
@@ -182,7 +183,7 @@ func TestPut(t *testing.T) {
 	if goal.SharedCache != nil {
 		key := goal.CacheKey(user)
 		var redisUser testuser
-		goal.SharedCache.Get(key, &redisUser)
+		goal.SharedCache.Get(context.Background(), key, &redisUser)
 		if !reflect.DeepEqual(result, redisUser) {
 			t.Error("Incorrect data in redis, ", result, redisUser)
 		}
@@ -230,7 +231,7 @@ func TestPutConflict(t *testing.T) {
 	if goal.SharedCache != nil {
 		key := goal.CacheKey(user)
 		var redisUser testuser
-		goal.SharedCache.Get(key, &redisUser)
+		goal.SharedCache.Get(context.Background(), key, &redisUser)
 		if !reflect.DeepEqual(result, redisUser) {
 			t.Error("Incorrect data in redis, ", result, redisUser)
 		}
@@ -282,7 +283,7 @@ func TestDelete(t *testing.T) {
 	// Make sure no more data in redis
 	if goal.SharedCache != nil {
 		key := goal.CacheKey(user)
-		if exist, _ := goal.SharedCache.Exists(key); exist {
+		if exist, _ := goal.SharedCache.Exists(context.Background(), key); exist {
 			t.Error("Data should be deleted from Redis when object is deleted")
 		}
 	}
@@ -0,0 +1,206 @@
+package goal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// FieldPermission restricts reading and writing a field to users
+// having one of the roles. Empty list means no restriction
+type FieldPermission struct {
+	Read  []string
+	Write []string
+}
+
+// FieldPermitter declares field permissions of a model, keyed by
+// struct field name, e.g. {"Salary": {Read: []string{"hr"}}}
+type FieldPermitter interface {
+	FieldPermissions() map[string]FieldPermission
+}
+
+// FieldPermissionError is returned when request body contains
+// a field current user is not allowed to write
+type FieldPermissionError struct {
+	Field string
+}
+
+func (err *FieldPermissionError) Error() string {
+	return fmt.Sprintf("field is not writable: %s", err.Field)
+}
+
+// fieldAllowed checks if user has one of the roles
+func fieldAllowed(roles []string, userRoles []string) bool {
+	if len(roles) == 0 {
+		return true
+	}
+
+	for _, role := range roles {
+		for _, userRole := range userRoles {
+			if role == userRole {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// RedactFields resets fields the user is not allowed to read to their
+// zero value. It walks pointers, slices and nested structs, so
+// included relations are redacted too. User can be nil
+func RedactFields(value interface{}, user interface{}) {
+	if value == nil {
+		return
+	}
+
+	var userRoles []string
+	if user != nil {
+		userRoles = UserRoles(user)
+	}
+
+	redactValue(reflect.ValueOf(value), userRoles, make(map[uintptr]bool))
+}
+
+// redactForRequest redacts value for current user of the request,
+// nothing is redacted for master key requests
+func redactForRequest(value interface{}, request *http.Request) {
+	if IsMasterRequest(request) {
+		return
+	}
+
+	user, _ := GetCurrentUser(request)
+	RedactFields(value, user)
+}
+
+func redactValue(v reflect.Value, userRoles []string, visited map[uintptr]bool) {
+	switch v.Kind() {
+	case reflect.Interface:
+		if !v.IsNil() {
+			redactValue(v.Elem(), userRoles, visited)
+		}
+	case reflect.Ptr:
+		if v.IsNil() || visited[v.Pointer()] {
+			return
+		}
+
+		visited[v.Pointer()] = true
+		redactValue(v.Elem(), userRoles, visited)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			redactValue(v.Index(i), userRoles, visited)
+		}
+	case reflect.Struct:
+		if v.CanAddr() {
+			if permitter, ok := v.Addr().Interface().(FieldPermitter); ok {
+				for name, permission := range permitter.FieldPermissions() {
+					if fieldAllowed(permission.Read, userRoles) {
+						continue
+					}
+
+					field := v.FieldByName(name)
+					if field.IsValid() && field.CanSet() {
+						field.Set(reflect.Zero(field.Type()))
+					}
+				}
+			}
+		}
+
+		// Walk nested values, e.g. preloaded associations
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).PkgPath != "" {
+				continue
+			}
+
+			switch v.Field(i).Kind() {
+			case reflect.Ptr, reflect.Slice, reflect.Struct, reflect.Interface:
+				redactValue(v.Field(i), userRoles, visited)
+			}
+		}
+	}
+}
+
+// jsonFieldNames maps json keys to struct field names, including
+// promoted fields
+func jsonFieldNames(t reflect.Type) map[string]string {
+	names := make(map[string]string)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t.Kind() != reflect.Struct {
+		return names
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name := strings.Split(tag, ",")[0]
+		if field.Anonymous && name == "" {
+			for key, promoted := range jsonFieldNames(field.Type) {
+				if _, exists := names[key]; !exists {
+					names[key] = promoted
+				}
+			}
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		names[name] = field.Name
+	}
+
+	return names
+}
+
+// checkWritableFields returns FieldPermissionError if body contains
+// a field the user is not allowed to write
+func checkWritableFields(resource interface{}, body []byte, request *http.Request, user interface{}) error {
+	permitter, ok := resource.(FieldPermitter)
+	if !ok || IsMasterRequest(request) {
+		return nil
+	}
+
+	var values map[string]json.RawMessage
+	err := json.Unmarshal(body, &values)
+	if err != nil {
+		return err
+	}
+
+	permissions := permitter.FieldPermissions()
+	names := jsonFieldNames(reflect.TypeOf(resource))
+
+	var userRoles []string
+	if user != nil {
+		userRoles = contextUserRoles(request.Context(), user)
+	}
+
+	// encoding/json matches keys with unicode case folding, so a key
+	// like "ſtatus" still sets Status
+	for key := range values {
+		for jsonName, name := range names {
+			if !strings.EqualFold(key, jsonName) {
+				continue
+			}
+
+			permission, ok := permissions[name]
+			if ok && !fieldAllowed(permission.Write, userRoles) {
+				return &FieldPermissionError{Field: key}
+			}
+		}
+	}
+
+	return nil
+}
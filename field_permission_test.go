@@ -0,0 +1,108 @@
+package goal_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/thomasdao/goal"
+)
+
+func (e *employee) FieldPermissions() map[string]goal.FieldPermission {
+	return map[string]goal.FieldPermission{
+		"Salary": {Read: []string{"hr"}, Write: []string{"hr"}},
+		"Status": {Write: []string{"moderator"}},
+	}
+}
+
+func (e *employee) Get(w http.ResponseWriter, request *http.Request) (int, interface{}, error) {
+	return goal.Read(reflect.TypeOf(e), request)
+}
+
+func (e *employee) Post(w http.ResponseWriter, request *http.Request) (int, interface{}, error) {
+	return goal.Create(reflect.TypeOf(e), request)
+}
+
+func (e *employee) Put(w http.ResponseWriter, request *http.Request) (int, interface{}, error) {
+	return goal.Update(reflect.TypeOf(e), request)
+}
+
+func (e *employee) Query(w http.ResponseWriter, request *http.Request) (int, interface{}, error) {
+	return goal.HandleQuery(reflect.TypeOf(e), request)
+}
+
+func TestFieldPermissions(t *testing.T) {
+	setup()
+	defer tearDown()
+
+	goal.EnableAPIKeys()
+	_, hrKey, _ := goal.CreateAPIKey("hr", []string{"hr"}, nil)
+	_, moderatorKey, _ := goal.CreateAPIKey("moderator", []string{"moderator"}, nil)
+
+	send := func(method string, path string, body string, key string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set(goal.APIKeyHeader, key)
+		}
+		goal.SharedAPI().Mux().ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	emp := &employee{Name: "Thomas", Salary: 100, Status: "active"}
+	db.Create(emp)
+	path := fmt.Sprint("/employee/", emp.ID)
+
+	// Salary is only readable by hr
+	var result employee
+	res := send("GET", path, "", "")
+	json.Unmarshal(res.Body.Bytes(), &result)
+	if result.Salary != 0 || result.Name != "Thomas" {
+		t.Error("Salary should be redacted", res.Body)
+	}
+
+	res = send("GET", path, "", hrKey)
+	json.Unmarshal(res.Body.Bytes(), &result)
+	if result.Salary != 100 {
+		t.Error("Hr should read salary", res.Body)
+	}
+
+	var results []employee
+	res = send("GET", "/query/employee/"+url.QueryEscape(`{"where":[]}`), "", "")
+	json.Unmarshal(res.Body.Bytes(), &results)
+	if len(results) != 1 || results[0].Salary != 0 {
+		t.Error("Salary should be redacted from query results", res.Body)
+	}
+
+	// Status is only writable by moderator
+	res = send("POST", "/employee", `{"Name":"Eve","status":"admin"}`, "")
+	if res.Code != 403 {
+		t.Error("Status should not be writable", res.Code)
+	}
+
+	res = send("PUT", path, `{"Status":"suspended"}`, hrKey)
+	if res.Code != 403 {
+		t.Error("Status should not be writable by hr", res.Code)
+	}
+
+	// Keys folded like encoding/json are restricted too
+	res = send("PUT", path, `{"ſtatus":"fired","ſalary":1}`, "")
+	if res.Code != 403 {
+		t.Error("Folded keys should not bypass field permissions", res.Code)
+	}
+
+	res = send("PUT", path, `{"Status":"suspended"}`, moderatorKey)
+	if res.Code != 200 {
+		t.Error("Moderator should write status", res.Code, res.Body)
+	}
+
+	db.First(&result, emp.ID)
+	if result.Status != "suspended" || result.Salary != 100 {
+		t.Error("Unexpected stored employee", result)
+	}
+}
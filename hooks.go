@@ -0,0 +1,112 @@
+package goal
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Trigger is passed to lifecycle hooks of a model
+type Trigger struct {
+	Request   *http.Request
+	Operation Operation
+
+	// User is current user, nil for anonymous requests
+	User interface{}
+
+	// Master is true for requests authenticated with master key
+	Master bool
+
+	// Object is the record: the new record on create, the stored record
+	// on update and delete, and each loaded record after find
+	Object interface{}
+
+	// Changes is the decoded request body on create and update. It is
+	// the same value as Object on create. Modify it to change what is saved
+	Changes interface{}
+
+	// Query is the database query before find, a hook can replace it
+	// to add conditions
+	Query *gorm.DB
+}
+
+// BeforeSaveHooker runs before a record is created or updated.
+// Returning an error rejects the request
+type BeforeSaveHooker interface {
+	HookBeforeSave(*Trigger) error
+}
+
+// AfterSaveHooker runs after a record is created or updated
+type AfterSaveHooker interface {
+	HookAfterSave(*Trigger) error
+}
+
+// BeforeDeleteHooker runs before a record is deleted. Returning an
+// error rejects the request
+type BeforeDeleteHooker interface {
+	HookBeforeDelete(*Trigger) error
+}
+
+// AfterDeleteHooker runs after a record is deleted
+type AfterDeleteHooker interface {
+	HookAfterDelete(*Trigger) error
+}
+
+// BeforeFindHooker runs before records are read or queried. It can
+// modify Query of the trigger, or reject the request
+type BeforeFindHooker interface {
+	HookBeforeFind(*Trigger) error
+}
+
+// AfterFindHooker runs for each record read or queried, after
+// permissions are checked. Returning an error rejects the request
+type AfterFindHooker interface {
+	HookAfterFind(*Trigger) error
+}
+
+// HookError lets a hook choose the status code and data of the
+// error response. Other errors returned by hooks respond with 400
+type HookError struct {
+	Code    int
+	Message string
+	Data    interface{}
+}
+
+func (err *HookError) Error() string {
+	return err.Message
+}
+
+// newTrigger prepares a trigger for current request
+func newTrigger(request *http.Request, operation Operation, object interface{}, changes interface{}) *Trigger {
+	user, _ := GetCurrentUser(request)
+	return &Trigger{
+		Request:   request,
+		Operation: operation,
+		User:      user,
+		Master:    IsMasterRequest(request),
+		Object:    object,
+		Changes:   changes,
+	}
+}
+
+// hookResponse converts an error of a hook into handler results
+func hookResponse(err error) (int, interface{}, error) {
+	if hookErr, ok := err.(*HookError); ok {
+		code := hookErr.Code
+		if code == 0 {
+			code = 400
+		}
+		return code, hookErr.Data, hookErr
+	}
+
+	return 400, nil, err
+}
+
+// logHookError prints errors of after hooks, the change is already
+// saved so they can't reject the request
+func logHookError(name string, err error) {
+	if err != nil {
+		fmt.Println(name, err)
+	}
+}
@@ -0,0 +1,145 @@
+package goal_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/thomasdao/goal"
+)
+
+var savedTasks, deletedTasks int
+
+func (t *task) Get(w http.ResponseWriter, request *http.Request) (int, interface{}, error) {
+	return goal.Read(reflect.TypeOf(t), request)
+}
+
+func (t *task) Post(w http.ResponseWriter, request *http.Request) (int, interface{}, error) {
+	return goal.Create(reflect.TypeOf(t), request)
+}
+
+func (t *task) Put(w http.ResponseWriter, request *http.Request) (int, interface{}, error) {
+	return goal.Update(reflect.TypeOf(t), request)
+}
+
+func (t *task) Delete(w http.ResponseWriter, request *http.Request) (int, interface{}, error) {
+	return goal.Delete(reflect.TypeOf(t), request)
+}
+
+func (t *task) Query(w http.ResponseWriter, request *http.Request) (int, interface{}, error) {
+	return goal.HandleQuery(reflect.TypeOf(t), request)
+}
+
+func (t *task) HookBeforeSave(trigger *goal.Trigger) error {
+	changes := trigger.Changes.(*task)
+	changes.Title = strings.TrimSpace(changes.Title)
+	if trigger.Operation == goal.OperationCreate && changes.Title == "" {
+		return &goal.HookError{Code: 422, Message: "title is required", Data: "Title"}
+	}
+
+	return nil
+}
+
+func (t *task) HookAfterSave(trigger *goal.Trigger) error {
+	savedTasks++
+	return nil
+}
+
+func (t *task) HookBeforeDelete(trigger *goal.Trigger) error {
+	if trigger.Object.(*task).Archived {
+		return &goal.HookError{Code: 403, Message: "archived task can't be deleted"}
+	}
+
+	return nil
+}
+
+func (t *task) HookAfterDelete(trigger *goal.Trigger) error {
+	deletedTasks++
+	return nil
+}
+
+func (t *task) HookBeforeFind(trigger *goal.Trigger) error {
+	if !trigger.Master {
+		trigger.Query = trigger.Query.Where("archived = ?", false)
+	}
+
+	return nil
+}
+
+func (t *task) HookAfterFind(trigger *goal.Trigger) error {
+	found := trigger.Object.(*task)
+	found.Summary = "Task: " + found.Title
+	return nil
+}
+
+func TestHooks(t *testing.T) {
+	setup()
+	defer tearDown()
+
+	savedTasks, deletedTasks = 0, 0
+
+	send := func(method string, path string, body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		goal.SharedAPI().Mux().ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	// Before save rejects with typed error
+	res := send("POST", "/task", `{"Title":"  "}`)
+	if res.Code != 422 || !strings.Contains(res.Body.String(), "title is required") {
+		t.Error("Empty title should be rejected", res.Code, res.Body)
+	}
+
+	// Before save mutates the record
+	res = send("POST", "/task", `{"Title":"  Write tests  "}`)
+	var created task
+	json.Unmarshal(res.Body.Bytes(), &created)
+	if res.Code != 200 || created.Title != "Write tests" {
+		t.Fatal("Title should be trimmed", res.Code, res.Body)
+	}
+
+	path := fmt.Sprint("/task/", created.ID)
+	res = send("PUT", path, `{"Title":" Edited "}`)
+	if res.Code != 200 || savedTasks != 2 {
+		t.Error("After save should run on create and update", res.Code, savedTasks)
+	}
+
+	// After find decorates the record
+	var found task
+	json.Unmarshal(send("GET", path, "").Body.Bytes(), &found)
+	if found.Title != "Edited" || found.Summary != "Task: Edited" {
+		t.Error("After find should run on read", found)
+	}
+
+	// Before find hides archived tasks
+	archived := &task{Title: "Old", Archived: true}
+	db.Create(archived)
+
+	if res = send("GET", fmt.Sprint("/task/", archived.ID), ""); res.Code == 200 {
+		t.Error("Archived task should be hidden", res.Body)
+	}
+
+	query := "/query/task/" + url.QueryEscape(`{"where":[],"limit":10}`)
+	var results []task
+	json.Unmarshal(send("GET", query, "").Body.Bytes(), &results)
+	if len(results) != 1 || results[0].Summary != "Task: Edited" {
+		t.Error("Query should run find hooks", results)
+	}
+
+	// Before delete rejects
+	db.Model(&created).Update("archived", true)
+	if res = send("DELETE", path, ""); res.Code != 403 {
+		t.Error("Archived task should not be deleted", res.Code)
+	}
+
+	db.Model(&created).Update("archived", false)
+	if res = send("DELETE", path, ""); res.Code != 200 || deletedTasks != 1 {
+		t.Error("Task should be deleted", res.Code, deletedTasks)
+	}
+}
@@ -0,0 +1,178 @@
+package goal
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Invalidation lists cache keys changed by a node
+type Invalidation struct {
+	Node string   `json:"node"`
+	Keys []string `json:"keys"`
+
+	// All drops every key of the local cache. A subscription sends it
+	// to its own handler after reconnecting, since changes published
+	// in the meantime are lost
+	All bool `json:"all,omitempty"`
+}
+
+// InvalidationBus broadcasts changed cache keys between app instances,
+// so each of them can drop stale copies from its local cache
+type InvalidationBus interface {
+	Publish(context.Context, *Invalidation) error
+
+	// Subscribe calls the handler for every invalidation published
+	// until the returned function is called
+	Subscribe(func(*Invalidation)) (func(), error)
+}
+
+// NodeID identifies this process on the invalidation bus
+var NodeID = newRequestID()
+
+var (
+	invalidationBus InvalidationBus
+	unsubscribeBus  func()
+)
+
+// RegisterInvalidationBus publishes keys changed by the create, update
+// and delete callbacks to bus, and deletes keys changed by other
+// nodes from local, the cache tier private to this process, e.g. L1
+// of TwoTierCache. Pass nil bus to stop
+func RegisterInvalidationBus(bus InvalidationBus, local Cacher) error {
+	if unsubscribeBus != nil {
+		unsubscribeBus()
+		unsubscribeBus = nil
+	}
+
+	invalidationBus = bus
+	if bus == nil {
+		return nil
+	}
+
+	if local != nil {
+		unsubscribe, err := bus.Subscribe(func(invalidation *Invalidation) {
+			if invalidation.All {
+				clearLocal(local)
+				return
+			}
+
+			if invalidation.Node == NodeID {
+				return
+			}
+
+			err := deleteMulti(context.Background(), local, invalidation.Keys)
+			reportCacheError("delete", strings.Join(invalidation.Keys, " "), err)
+		})
+		if err != nil {
+			invalidationBus = nil
+			return err
+		}
+
+		unsubscribeBus = unsubscribe
+	}
+
+	if db != nil {
+		db.Callback().Create().After("goal:forget_not_found").Register("goal:publish_after_create", publishChanges)
+		db.Callback().Update().After("goal:cache_after_update").Register("goal:publish_after_update", publishChanges)
+		db.Callback().Delete().After("goal:uncache_after_delete").Register("goal:publish_after_delete", publishChanges)
+	}
+
+	return nil
+}
+
+// clearLocal drops every key of the local cache, which must implement
+// PrefixClearer
+func clearLocal(local Cacher) {
+	clearer, ok := local.(PrefixClearer)
+	if !ok {
+		reportCacheError("delete", "*", errors.New("local cache can't clear keys by prefix"))
+		return
+	}
+
+	reportCacheError("delete", "*", clearer.ClearPrefix(context.Background(), ""))
+}
+
+// publishInvalidation sends keys changed by this node to the bus
+func publishInvalidation(ctx context.Context, keys ...string) {
+	if invalidationBus == nil || len(keys) == 0 {
+		return
+	}
+
+	err := invalidationBus.Publish(ctx, &Invalidation{Node: NodeID, Keys: keys})
+	reportCacheError("publish", strings.Join(keys, " "), err)
+}
+
+// publishChanges publishes keys of records changed by the scope. A
+// created record also clears its not found marker on other nodes
+func publishChanges(scope *gorm.Scope) {
+	if invalidationBus == nil || scope.HasError() || !hasPrimaryKey(scope) {
+		return
+	}
+
+	keys := collectedCacheKeys(scope)
+	if keys == nil && !scope.PrimaryKeyZero() {
+		keys = []string{
+			cacheKeyFromScope(scope),
+			notFoundKey(scope.TableName(), scope.PrimaryKeyValue()),
+		}
+	}
+
+	publishInvalidation(scopeContext(scope), keys...)
+}
+
+// LocalBus is an in-process InvalidationBus, e.g. for tests. Handlers
+// are called synchronously by Publish
+type LocalBus struct {
+	mutex    sync.RWMutex
+	next     int
+	handlers map[int]func(*Invalidation)
+}
+
+// NewLocalBus creates an empty LocalBus
+func NewLocalBus() *LocalBus {
+	return &LocalBus{handlers: make(map[int]func(*Invalidation))}
+}
+
+// Publish conforms to InvalidationBus interface
+func (bus *LocalBus) Publish(ctx context.Context, invalidation *Invalidation) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	bus.mutex.RLock()
+	handlers := make([]func(*Invalidation), 0, len(bus.handlers))
+	for _, handler := range bus.handlers {
+		handlers = append(handlers, handler)
+	}
+	bus.mutex.RUnlock()
+
+	for _, handler := range handlers {
+		handler(invalidation)
+	}
+
+	return nil
+}
+
+// Subscribe conforms to InvalidationBus interface
+func (bus *LocalBus) Subscribe(handler func(*Invalidation)) (func(), error) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	if bus.handlers == nil {
+		bus.handlers = make(map[int]func(*Invalidation))
+	}
+
+	id := bus.next
+	bus.next++
+	bus.handlers[id] = handler
+
+	return func() {
+		bus.mutex.Lock()
+		delete(bus.handlers, id)
+		bus.mutex.Unlock()
+	}, nil
+}
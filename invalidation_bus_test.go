@@ -0,0 +1,124 @@
+package goal_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/thomasdao/goal"
+)
+
+func TestInvalidationBus(t *testing.T) {
+	setup()
+	defer tearDown()
+
+	ctx := context.Background()
+	bus := goal.NewLocalBus()
+	local := goal.NewMemoryCache(0, 0)
+
+	err := goal.RegisterInvalidationBus(bus, local)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer goal.RegisterInvalidationBus(nil, nil)
+
+	// Local cache of another node, in this process all nodes share
+	// the same NodeID
+	remote := goal.NewMemoryCache(0, 0)
+	unsubscribe, _ := bus.Subscribe(func(invalidation *goal.Invalidation) {
+		for _, key := range invalidation.Keys {
+			remote.Delete(ctx, key)
+		}
+	})
+	defer unsubscribe()
+
+	user := &testuser{Name: "Thomas", Age: 28}
+	db.Create(user)
+	key := goal.CacheKey(user)
+	remote.Set(ctx, key, user)
+
+	db.Model(user).Update("name", "Thomas Dao")
+	if exists, _ := remote.Exists(ctx, key); exists {
+		t.Error("Update should invalidate caches of other nodes")
+	}
+
+	remote.Set(ctx, key, user)
+	db.Where("age = ?", 28).Delete(&testuser{})
+	if exists, _ := remote.Exists(ctx, key); exists {
+		t.Error("Bulk delete should invalidate caches of other nodes")
+	}
+
+	// Changes of other nodes are removed from local cache, changes
+	// of this node are ignored
+	local.Set(ctx, key, user)
+	bus.Publish(ctx, &goal.Invalidation{Node: goal.NodeID, Keys: []string{key}})
+	if exists, _ := local.Exists(ctx, key); !exists {
+		t.Error("Own changes should not invalidate local cache")
+	}
+
+	bus.Publish(ctx, &goal.Invalidation{Node: "other", Keys: []string{key}})
+	if exists, _ := local.Exists(ctx, key); exists {
+		t.Error("Changes of other nodes should invalidate local cache")
+	}
+
+	// Subscription which may have lost messages drops everything
+	local.Set(ctx, key, user)
+	bus.Publish(ctx, &goal.Invalidation{All: true})
+	if exists, _ := local.Exists(ctx, key); exists {
+		t.Error("Local cache should be cleared")
+	}
+}
+
+func TestRedisBus(t *testing.T) {
+	setup()
+	defer tearDown()
+
+	if goal.Pool() == nil {
+		t.Skip("Redis is not available")
+	}
+
+	ctx := context.Background()
+	bus := &goal.RedisBus{Channel: "goal:test:invalidation"}
+	received := make(chan *goal.Invalidation, 1)
+	unsubscribe, err := bus.Subscribe(func(invalidation *goal.Invalidation) {
+		received <- invalidation
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer unsubscribe()
+
+	// Wait for the subscription
+	time.Sleep(100 * time.Millisecond)
+
+	err = bus.Publish(ctx, &goal.Invalidation{Node: "other", Keys: []string{"testuser:1"}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	select {
+	case invalidation := <-received:
+		if invalidation.Node != "other" || len(invalidation.Keys) != 1 || invalidation.Keys[0] != "testuser:1" {
+			t.Error("Invalid message", invalidation)
+		}
+	case <-time.After(time.Second):
+		t.Error("Message should be received")
+	}
+
+	// Local caches are cleared once the subscription is restored
+	conn := goal.Pool().Get()
+	defer conn.Close()
+	conn.Do("CLIENT", "KILL", "TYPE", "pubsub")
+
+	select {
+	case invalidation := <-received:
+		if !invalidation.All {
+			t.Error("Reconnect should clear local caches", invalidation)
+		}
+	case <-time.After(3 * time.Second):
+		t.Error("Subscription should reconnect")
+	}
+}
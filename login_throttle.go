@@ -0,0 +1,194 @@
+package goal
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LoginThrottle limits failed login attempts per username and per IP
+// address. After MaxAttempts failures inside Window, the username or IP
+// is locked for LockoutDuration. Each following lockout doubles the
+// duration, up to MaxLockoutDuration
+type LoginThrottle struct {
+	MaxAttempts        int
+	Window             time.Duration
+	LockoutDuration    time.Duration
+	MaxLockoutDuration time.Duration
+
+	// TrustForwardedFor uses X-Forwarded-For header as client IP,
+	// only enable it when running behind a trusted proxy
+	TrustForwardedFor bool
+
+	mutex sync.Mutex
+}
+
+// SharedLoginThrottle is used by LoginWithPassword, set it to nil
+// with SetLoginThrottle to disable the protection
+var SharedLoginThrottle = &LoginThrottle{
+	MaxAttempts:        5,
+	Window:             15 * time.Minute,
+	LockoutDuration:    time.Minute,
+	MaxLockoutDuration: time.Hour,
+}
+
+// SetLoginThrottle replaces SharedLoginThrottle
+func SetLoginThrottle(throttle *LoginThrottle) {
+	SharedLoginThrottle = throttle
+}
+
+// LoginLockedError is returned when a username or IP address is
+// temporarily locked. Handlers should respond with 429 status code,
+// Retry-After header is already set by LoginWithPassword
+type LoginLockedError struct {
+	RetryAfter time.Duration
+}
+
+func (err *LoginLockedError) Error() string {
+	return "too many failed login attempts"
+}
+
+// loginAttempts is stored in the cache for each username and IP
+type loginAttempts struct {
+	Failures    int       `json:"failures"`
+	First       time.Time `json:"first"`
+	Last        time.Time `json:"last"`
+	LockedUntil time.Time `json:"locked_until"`
+	Lockouts    int       `json:"lockouts"`
+}
+
+func usernameAttemptsKey(username string) string {
+	return stateKey(fmt.Sprintf("goal:login:user:%s", username))
+}
+
+func ipAttemptsKey(ip string) string {
+	return stateKey(fmt.Sprintf("goal:login:ip:%s", ip))
+}
+
+func (throttle *LoginThrottle) load(ctx context.Context, key string, now time.Time) *loginAttempts {
+	attempts := &loginAttempts{}
+	err := stateStore().Get(ctx, key, attempts)
+	if err != nil {
+		return &loginAttempts{}
+	}
+
+	// Forget lockout history after a quiet period
+	if now.Sub(attempts.Last) > throttle.Window+throttle.MaxLockoutDuration {
+		return &loginAttempts{}
+	}
+
+	// Failures only count inside the window
+	if now.Sub(attempts.First) > throttle.Window && now.After(attempts.LockedUntil) {
+		attempts.Failures = 0
+	}
+
+	return attempts
+}
+
+// retryAfter returns how long the client must wait before trying
+// again, zero means login attempt is allowed
+func (throttle *LoginThrottle) retryAfter(ctx context.Context, username string, ip string) time.Duration {
+	throttle.mutex.Lock()
+	defer throttle.mutex.Unlock()
+
+	now := time.Now()
+	var wait time.Duration
+	for _, key := range []string{usernameAttemptsKey(username), ipAttemptsKey(ip)} {
+		attempts := throttle.load(ctx, key, now)
+		if remaining := attempts.LockedUntil.Sub(now); remaining > wait {
+			wait = remaining
+		}
+	}
+
+	return wait
+}
+
+// fail records a failed attempt and locks the username or IP
+// if threshold is reached
+func (throttle *LoginThrottle) fail(ctx context.Context, username string, ip string) {
+	throttle.mutex.Lock()
+	defer throttle.mutex.Unlock()
+
+	now := time.Now()
+	keys := map[string]string{
+		usernameAttemptsKey(username): "username",
+		ipAttemptsKey(ip):             "ip",
+	}
+
+	for key, kind := range keys {
+		attempts := throttle.load(ctx, key, now)
+		if attempts.Failures == 0 {
+			attempts.First = now
+		}
+		attempts.Failures++
+		attempts.Last = now
+
+		if attempts.Failures >= throttle.MaxAttempts {
+			duration := throttle.lockoutDuration(attempts.Lockouts)
+			attempts.LockedUntil = now.Add(duration)
+			attempts.Lockouts++
+			attempts.Failures = 0
+
+			audit(&AuditRecord{
+				Time:     now,
+				Event:    "login.lockout",
+				Username: username,
+				IP:       ip,
+				Detail:   fmt.Sprintf("%s locked for %v after %d failed attempts", kind, duration, throttle.MaxAttempts),
+			})
+		}
+
+		// Attempts are forgotten after a quiet period anyway
+		setWithTTL(ctx, stateStore(), key, attempts, throttle.Window+throttle.MaxLockoutDuration)
+	}
+}
+
+// succeed clears failed attempts of the username. Attempts of
+// the IP are kept, so one valid account can't reset them
+func (throttle *LoginThrottle) succeed(ctx context.Context, username string) {
+	throttle.mutex.Lock()
+	defer throttle.mutex.Unlock()
+
+	stateStore().Delete(ctx, usernameAttemptsKey(username))
+}
+
+func (throttle *LoginThrottle) lockoutDuration(lockouts int) time.Duration {
+	if lockouts > 30 {
+		lockouts = 30
+	}
+
+	duration := throttle.LockoutDuration * time.Duration(math.Pow(2, float64(lockouts)))
+	if throttle.MaxLockoutDuration > 0 && (duration > throttle.MaxLockoutDuration || duration <= 0) {
+		duration = throttle.MaxLockoutDuration
+	}
+
+	return duration
+}
+
+// clientIP returns IP address of the request
+func (throttle *LoginThrottle) clientIP(request *http.Request) string {
+	if throttle.TrustForwardedFor {
+		forwarded := request.Header.Get("X-Forwarded-For")
+		if forwarded != "" {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+
+	return host
+}
+
+// setRetryAfter writes Retry-After header in seconds
+func setRetryAfter(w http.ResponseWriter, retry time.Duration) {
+	seconds := int(math.Ceil(retry.Seconds()))
+	w.Header().Set("Retry-After", fmt.Sprint(seconds))
+}
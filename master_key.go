@@ -0,0 +1,57 @@
+package goal
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+)
+
+// MasterKeyHeader is the request header carrying the master key
+const MasterKeyHeader = "X-Goal-Master-Key"
+
+// SetMasterKey enables master key. Requests having MasterKeyHeader
+// with this key bypass record permissions, ACLs and field restrictions,
+// which is useful for admin tools and scripts. Keep it secret and never
+// ship it to clients
+func (api *API) SetMasterKey(key string) {
+	api.masterKey = key
+}
+
+// IsMasterRequest checks if the request has the master key of the
+// API handling it
+func IsMasterRequest(request *http.Request) bool {
+	if request == nil {
+		return false
+	}
+
+	api := apiOf(request)
+	if api == nil || api.masterKey == "" {
+		return false
+	}
+
+	key := request.Header.Get(MasterKeyHeader)
+	if key == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(key), []byte(api.masterKey)) == 1
+}
+
+// auditMasterKey records every request using the master key,
+// including attempts with a wrong key
+func auditMasterKey(request *http.Request) {
+	if request.Header.Get(MasterKeyHeader) == "" {
+		return
+	}
+
+	event := "master_key.rejected"
+	if IsMasterRequest(request) {
+		event = "master_key.request"
+	}
+
+	audit(&AuditRecord{
+		Event:  event,
+		IP:     request.RemoteAddr,
+		Detail: fmt.Sprintf("%s %s", request.Method, request.URL.Path),
+	})
+}
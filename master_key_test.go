@@ -0,0 +1,102 @@
+package goal_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/thomasdao/goal"
+)
+
+type recordingAuditor struct {
+	records []*goal.AuditRecord
+}
+
+func (auditor *recordingAuditor) Audit(record *goal.AuditRecord) {
+	auditor.records = append(auditor.records, record)
+}
+
+func TestMasterKey(t *testing.T) {
+	setup()
+	defer tearDown()
+
+	auditor := &recordingAuditor{}
+	previous := goal.SharedAuditor
+	goal.RegisterAuditor(auditor)
+	defer goal.RegisterAuditor(previous)
+
+	goal.SharedAPI().SetMasterKey("master-secret")
+	defer goal.SharedAPI().SetMasterKey("")
+
+	private := &note{Text: "private"}
+	private.ACL = goal.NewACL()
+	db.Create(private)
+
+	emp := &employee{Name: "Thomas", Salary: 100}
+	db.Create(emp)
+
+	get := func(path string, key string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Set(goal.MasterKeyHeader, key)
+		goal.SharedAPI().Mux().ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	res := get(fmt.Sprint("/note/", private.ID), "wrong")
+	if res.Code != 403 {
+		t.Error("Wrong master key should not bypass ACL", res.Code)
+	}
+
+	res = get(fmt.Sprint("/note/", private.ID), "master-secret")
+	if res.Code != 200 {
+		t.Error("Master key should bypass ACL", res.Code)
+	}
+
+	var result employee
+	res = get(fmt.Sprint("/employee/", emp.ID), "master-secret")
+	json.Unmarshal(res.Body.Bytes(), &result)
+	if result.Salary != 100 {
+		t.Error("Master key should bypass field restrictions", res.Body)
+	}
+
+	if len(auditor.records) != 3 {
+		t.Fatal("Every master key request should be audited", len(auditor.records))
+	}
+
+	if auditor.records[0].Event != "master_key.rejected" || auditor.records[1].Event != "master_key.request" {
+		t.Error("Unexpected audit records", auditor.records[0], auditor.records[1])
+	}
+}
+
+func TestMasterKeyOfOtherAPI(t *testing.T) {
+	setup()
+	defer tearDown()
+
+	admin := &goal.API{}
+	admin.AddDefaultCrudPaths(&note{})
+	admin.SetMasterKey("admin-secret")
+
+	private := &note{Text: "private"}
+	private.ACL = goal.NewACL()
+	db.Create(private)
+
+	get := func(api *goal.API, key string) int {
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", fmt.Sprint("/note/", private.ID), nil)
+		req.Header.Set(goal.MasterKeyHeader, key)
+		api.Mux().ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	if code := get(admin, "admin-secret"); code != 200 {
+		t.Error("Master key of the API should bypass ACL", code)
+	}
+
+	// Key is not accepted by APIs it was not set on
+	if code := get(goal.SharedAPI(), "admin-secret"); code != 403 {
+		t.Error("Master key of another API should not bypass ACL", code)
+	}
+}
@@ -0,0 +1,405 @@
+package goal
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrCacheMiss is returned by Get when the key doesn't exist or
+// has expired
+var ErrCacheMiss = errors.New("cache miss")
+
+// MemoryCache is an in-process Cacher with LRU eviction. Values are
+// stored as json like RedisCache, so callers can't mutate cached values
+type MemoryCache struct {
+	// MaxEntries and MaxBytes bound the cache, zero means unlimited.
+	// Least recently used entries are evicted first
+	MaxEntries int
+	MaxBytes   int64
+
+	// TTL is the default expiry of entries, zero means never
+	TTL time.Duration
+
+	mutex     sync.Mutex
+	items     map[string]*list.Element
+	order     *list.List
+	bytes     int64
+	lastSweep time.Time
+}
+
+// memorySweepInterval is how often writes remove expired entries, so
+// entries which are never read again don't stay in memory
+const memorySweepInterval = time.Minute
+
+type memoryEntry struct {
+	key       string
+	data      []byte
+	expiresAt time.Time
+}
+
+func (entry *memoryEntry) size() int64 {
+	return int64(len(entry.key) + len(entry.data))
+}
+
+func (entry *memoryEntry) expired(now time.Time) bool {
+	return !entry.expiresAt.IsZero() && now.After(entry.expiresAt)
+}
+
+// NewMemoryCache returns a MemoryCache bounded by number of entries
+// and total bytes of keys and values, zero means unlimited
+func NewMemoryCache(maxEntries int, maxBytes int64) *MemoryCache {
+	return &MemoryCache{MaxEntries: maxEntries, MaxBytes: maxBytes}
+}
+
+func (cache *MemoryCache) init() {
+	if cache.items == nil {
+		cache.items = make(map[string]*list.Element)
+		cache.order = list.New()
+	}
+}
+
+// lookup returns live entry of the key and marks it as recently used,
+// mutex must be held
+func (cache *MemoryCache) lookup(key string) *memoryEntry {
+	cache.init()
+
+	element, ok := cache.items[key]
+	if !ok {
+		return nil
+	}
+
+	entry := element.Value.(*memoryEntry)
+	if entry.expired(time.Now()) {
+		cache.remove(element)
+		return nil
+	}
+
+	cache.order.MoveToFront(element)
+	return entry
+}
+
+func (cache *MemoryCache) remove(element *list.Element) {
+	entry := cache.order.Remove(element).(*memoryEntry)
+	delete(cache.items, entry.key)
+	cache.bytes -= entry.size()
+}
+
+// sweep removes expired entries, at most once per interval. Mutex
+// must be held
+func (cache *MemoryCache) sweep(now time.Time) {
+	if now.Sub(cache.lastSweep) < memorySweepInterval {
+		return
+	}
+
+	cache.removeExpired(now)
+}
+
+// removeExpired removes every expired entry, mutex must be held
+func (cache *MemoryCache) removeExpired(now time.Time) {
+	cache.lastSweep = now
+	for _, element := range cache.items {
+		if element.Value.(*memoryEntry).expired(now) {
+			cache.remove(element)
+		}
+	}
+}
+
+// store adds or replaces an entry and evicts entries over the bounds,
+// mutex must be held
+func (cache *MemoryCache) store(key string, data []byte, ttl time.Duration) {
+	cache.init()
+	cache.sweep(time.Now())
+
+	if element, ok := cache.items[key]; ok {
+		cache.remove(element)
+	}
+
+	entry := &memoryEntry{key: key, data: data}
+	if ttl > 0 {
+		entry.expiresAt = time.Now().Add(ttl)
+	}
+
+	// Entry larger than the cache is not stored
+	if cache.MaxBytes > 0 && entry.size() > cache.MaxBytes {
+		return
+	}
+
+	cache.items[key] = cache.order.PushFront(entry)
+	cache.bytes += entry.size()
+
+	for cache.overflow() {
+		cache.remove(cache.order.Back())
+	}
+}
+
+func (cache *MemoryCache) overflow() bool {
+	if cache.MaxEntries > 0 && cache.order.Len() > cache.MaxEntries {
+		return true
+	}
+
+	return cache.MaxBytes > 0 && cache.bytes > cache.MaxBytes
+}
+
+// Get conforms to Cacher interface
+func (cache *MemoryCache) Get(ctx context.Context, key string, val interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	cache.mutex.Lock()
+	entry := cache.lookup(key)
+	cache.mutex.Unlock()
+
+	if entry == nil {
+		return ErrCacheMiss
+	}
+
+	// Data is never modified after stored, so it can be decoded
+	// outside of the lock
+	return json.Unmarshal(entry.data, val)
+}
+
+// Set conforms to Cacher interface, entry expires after default TTL
+func (cache *MemoryCache) Set(ctx context.Context, key string, val interface{}) error {
+	return cache.SetWithTTL(ctx, key, val, cache.TTL)
+}
+
+// SetWithTTL conforms to ExpiringCacher interface
+func (cache *MemoryCache) SetWithTTL(ctx context.Context, key string, val interface{}, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+
+	cache.mutex.Lock()
+	cache.store(key, data, ttl)
+	cache.mutex.Unlock()
+
+	return nil
+}
+
+// Touch conforms to ExpiringCacher interface
+func (cache *MemoryCache) Touch(ctx context.Context, key string, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	entry := cache.lookup(key)
+	if entry == nil {
+		return ErrCacheMiss
+	}
+
+	entry.expiresAt = time.Time{}
+	if ttl > 0 {
+		entry.expiresAt = time.Now().Add(ttl)
+	}
+
+	return nil
+}
+
+// Delete conforms to Cacher interface
+func (cache *MemoryCache) Delete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	cache.init()
+	if element, ok := cache.items[key]; ok {
+		cache.remove(element)
+	}
+
+	return nil
+}
+
+// Exists conforms to Cacher interface
+func (cache *MemoryCache) Exists(ctx context.Context, key string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	return cache.lookup(key) != nil, nil
+}
+
+// GetMulti conforms to BatchCacher interface
+func (cache *MemoryCache) GetMulti(ctx context.Context, keys []string, vals []interface{}) ([]bool, error) {
+	found := make([]bool, len(keys))
+	if err := ctx.Err(); err != nil {
+		return found, err
+	}
+
+	entries := make([]*memoryEntry, len(keys))
+	cache.mutex.Lock()
+	for i, key := range keys {
+		entries[i] = cache.lookup(key)
+	}
+	cache.mutex.Unlock()
+
+	for i, entry := range entries {
+		if entry == nil {
+			continue
+		}
+
+		err := json.Unmarshal(entry.data, vals[i])
+		if err != nil {
+			return found, err
+		}
+		found[i] = true
+	}
+
+	return found, nil
+}
+
+// SetMulti conforms to BatchCacher interface
+func (cache *MemoryCache) SetMulti(ctx context.Context, keys []string, vals []interface{}, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if ttl <= 0 {
+		ttl = cache.TTL
+	}
+
+	data := make([][]byte, len(keys))
+	for i := range keys {
+		var err error
+		data[i], err = json.Marshal(vals[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	for i, key := range keys {
+		cache.store(key, data[i], ttl)
+	}
+
+	return nil
+}
+
+// DeleteMulti conforms to BatchCacher interface
+func (cache *MemoryCache) DeleteMulti(ctx context.Context, keys []string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	cache.init()
+	for _, key := range keys {
+		if element, ok := cache.items[key]; ok {
+			cache.remove(element)
+		}
+	}
+
+	return nil
+}
+
+// Len returns number of entries, including expired entries not yet
+// removed
+func (cache *MemoryCache) Len() int {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	cache.init()
+	return cache.order.Len()
+}
+
+// Sweep removes expired entries now. Writes also sweep them
+// periodically
+func (cache *MemoryCache) Sweep() {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	cache.init()
+	cache.removeExpired(time.Now())
+}
+
+// Clear removes all entries
+func (cache *MemoryCache) Clear() {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	cache.items = nil
+	cache.order = nil
+	cache.bytes = 0
+	cache.lastSweep = time.Time{}
+}
+
+// ClearPrefix conforms to PrefixClearer interface
+func (cache *MemoryCache) ClearPrefix(ctx context.Context, prefix string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	cache.init()
+	for key, element := range cache.items {
+		if strings.HasPrefix(key, prefix) && !isStateKey(key) {
+			cache.remove(element)
+		}
+	}
+
+	return nil
+}
+
+// fallbackStore keeps internal state, e.g. failed login attempts and
+// invalidated sessions, when SharedCache is not registered. It is not
+// bounded, evicting that state would weaken security. Expired login
+// attempts are swept on write
+var fallbackStore = NewMemoryCache(0, 0)
+
+// stateKeyPrefixes are keys of internal state, after the cache
+// namespace. Losing them revives invalidated sessions, resets
+// failed login attempts or serves stale query results
+var stateKeyPrefixes = []string{"goal:login:", "goal:session:", "goal:roles:generation", "goal:query:generation:"}
+
+// isStateKey checks if the key holds internal state
+func isStateKey(key string) bool {
+	key = strings.TrimPrefix(key, stateNamespace)
+	for _, prefix := range stateKeyPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// stateStore returns SharedCache if registered, else an in-memory
+// store, so internal state works with or without a cache server. A
+// bounded MemoryCache could evict the state, fallbackStore is used
+// instead, which lives in the same process anyway
+func stateStore() Cacher {
+	if memory, ok := SharedCache.(*MemoryCache); ok && (memory.MaxEntries > 0 || memory.MaxBytes > 0) {
+		return fallbackStore
+	}
+
+	if SharedCache != nil {
+		return SharedCache
+	}
+
+	return fallbackStore
+}
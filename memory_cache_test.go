@@ -0,0 +1,157 @@
+package goal_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/thomasdao/goal"
+)
+
+func TestMemoryCache(t *testing.T) {
+	ctx := context.Background()
+	cache := goal.NewMemoryCache(2, 0)
+
+	user := &testuser{ID: 1, Name: "Thomas"}
+	cache.Set(ctx, "testuser:1", user)
+
+	// Cached value is a copy
+	user.Name = "Changed"
+	var cached testuser
+	if err := cache.Get(ctx, "testuser:1", &cached); err != nil || cached.Name != "Thomas" {
+		t.Error("Cached value should not change", cached, err)
+	}
+
+	if err := cache.Get(ctx, "missing", &cached); err != goal.ErrCacheMiss {
+		t.Error("Missing key should return ErrCacheMiss", err)
+	}
+
+	// Least recently used entry is evicted
+	cache.Set(ctx, "testuser:2", &testuser{ID: 2})
+	cache.Get(ctx, "testuser:1", &cached)
+	cache.Set(ctx, "testuser:3", &testuser{ID: 3})
+
+	if exists, _ := cache.Exists(ctx, "testuser:2"); exists {
+		t.Error("Least recently used entry should be evicted")
+	}
+
+	if exists, _ := cache.Exists(ctx, "testuser:1"); !exists || cache.Len() != 2 {
+		t.Error("Recently used entry should be kept", cache.Len())
+	}
+
+	cache.Delete(ctx, "testuser:1")
+	if exists, _ := cache.Exists(ctx, "testuser:1"); exists {
+		t.Error("Entry should be deleted")
+	}
+}
+
+func TestMemoryCacheBounds(t *testing.T) {
+	ctx := context.Background()
+
+	// Bytes bound
+	cache := goal.NewMemoryCache(0, 100)
+	for i := 0; i < 10; i++ {
+		cache.Set(ctx, fmt.Sprint("key:", i), "0123456789")
+	}
+
+	if cache.Len() >= 10 || cache.Len() == 0 {
+		t.Error("Cache should be bounded by bytes", cache.Len())
+	}
+
+	if exists, _ := cache.Exists(ctx, "key:9"); !exists {
+		t.Error("Newest entry should be kept")
+	}
+
+	// TTL
+	cache = goal.NewMemoryCache(0, 0)
+	cache.TTL = 20 * time.Millisecond
+	cache.Set(ctx, "short", 1)
+	time.Sleep(40 * time.Millisecond)
+
+	var value int
+	if err := cache.Get(ctx, "short", &value); err != goal.ErrCacheMiss {
+		t.Error("Entry should expire", err)
+	}
+}
+
+func TestMemoryCacheConcurrency(t *testing.T) {
+	ctx := context.Background()
+	cache := goal.NewMemoryCache(50, 0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				key := fmt.Sprint("key:", (worker*j)%80)
+				cache.Set(ctx, key, j)
+
+				var value int
+				cache.Get(ctx, key, &value)
+				cache.Exists(ctx, key)
+				if j%10 == 0 {
+					cache.Delete(ctx, key)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if cache.Len() > 50 {
+		t.Error("Cache should stay bounded", cache.Len())
+	}
+}
+
+func TestMemoryCacheTouch(t *testing.T) {
+	ctx := context.Background()
+	cache := goal.NewMemoryCache(0, 0)
+
+	cache.SetWithTTL(ctx, "key", 1, 30*time.Millisecond)
+	cache.Set(ctx, "forever", 1)
+
+	time.Sleep(20 * time.Millisecond)
+	if err := cache.Touch(ctx, "key", 30*time.Millisecond); err != nil {
+		t.Fatal("Fail to touch key", err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if exists, _ := cache.Exists(ctx, "key"); !exists {
+		t.Error("Touched key should not expire")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if exists, _ := cache.Exists(ctx, "key"); exists {
+		t.Error("Key should expire after ttl")
+	}
+
+	if err := cache.Touch(ctx, "key", time.Second); err != goal.ErrCacheMiss {
+		t.Error("Touch of missing key should return ErrCacheMiss", err)
+	}
+
+	if exists, _ := cache.Exists(ctx, "forever"); !exists {
+		t.Error("Key without ttl should not expire")
+	}
+}
+
+func TestMemoryCacheSweep(t *testing.T) {
+	ctx := context.Background()
+	cache := goal.NewMemoryCache(0, 0)
+
+	// Expired entries which are never read again are swept
+	for i := 0; i < 10; i++ {
+		cache.SetWithTTL(ctx, fmt.Sprint("login:", i), i, time.Nanosecond)
+	}
+	cache.Set(ctx, "forever", 1)
+
+	cache.Sweep()
+	if cache.Len() != 1 {
+		t.Error("Expired entries should be removed", cache.Len())
+	}
+
+	if exists, _ := cache.Exists(ctx, "forever"); !exists {
+		t.Error("Key without ttl should not be swept")
+	}
+}
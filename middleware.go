@@ -0,0 +1,317 @@
+package goal
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Operation is the kind of request handled by goal
+type Operation string
+
+// Operations passed to middlewares
+const (
+	OperationRead   Operation = "read"
+	OperationCreate Operation = "create"
+	OperationUpdate Operation = "update"
+	OperationDelete Operation = "delete"
+	OperationQuery  Operation = "query"
+	OperationAuth   Operation = "auth"
+	OperationAdmin  Operation = "admin"
+)
+
+// Call holds a request going through middlewares. Resource is the
+// registered model, nil for admin paths. Code, Result and Err are
+// set by the handler, middlewares can read or replace them after
+// calling next
+type Call struct {
+	Writer    http.ResponseWriter
+	Request   *http.Request
+	Resource  interface{}
+	Operation Operation
+
+	Code   int
+	Result interface{}
+	Err    error
+}
+
+// CurrentUser returns current user of the request
+func (call *Call) CurrentUser() (interface{}, error) {
+	return GetCurrentUser(call.Request)
+}
+
+// Handler processes a call
+type Handler func(*Call)
+
+// Middleware wraps a handler. A middleware can stop the request by
+// setting Code and Err without calling next
+type Middleware func(next Handler) Handler
+
+// Use adds middlewares to the API, the first one runs outermost
+func (api *API) Use(middlewares ...Middleware) {
+	api.middlewares = append(api.middlewares, middlewares...)
+}
+
+// chain wraps the handler with middlewares of the API
+func (api *API) chain(handler simpleResponse) Handler {
+	next := func(call *Call) {
+		if handler == nil {
+			call.Code, call.Err = http.StatusMethodNotAllowed, http.ErrNotSupported
+			return
+		}
+
+		call.Code, call.Result, call.Err = handler(call.Writer, call.Request)
+	}
+
+	for i := len(api.middlewares) - 1; i >= 0; i-- {
+		next = api.middlewares[i](next)
+	}
+
+	return next
+}
+
+// RequestIDHeader carries id of a request
+const RequestIDHeader = "X-Request-ID"
+
+// RequestID returns id of the request set by RequestIDMiddleware
+func RequestID(request *http.Request) string {
+	id, _ := request.Context().Value(requestIDContextKey).(string)
+	return id
+}
+
+// validRequestID accepts short printable ids sent by proxies
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+
+	for _, r := range id {
+		if r < 0x21 || r > 0x7e {
+			return false
+		}
+	}
+
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// RequestIDMiddleware reuses X-Request-ID header of the request or
+// generates a new id, echoes it in the response and stores it on the
+// request context, see RequestID
+func RequestIDMiddleware() Middleware {
+	return func(next Handler) Handler {
+		return func(call *Call) {
+			id := call.Request.Header.Get(RequestIDHeader)
+			if !validRequestID(id) {
+				id = newRequestID()
+			}
+
+			call.Writer.Header().Set(RequestIDHeader, id)
+			ctx := context.WithValue(call.Request.Context(), requestIDContextKey, id)
+			call.Request = call.Request.WithContext(ctx)
+
+			next(call)
+		}
+	}
+}
+
+// ErrInternal is returned to clients when a handler panics
+var ErrInternal = errors.New("internal server error")
+
+// RecoverMiddleware turns a panic of the handler into a json 500 error.
+// The panic and stack trace are printed, never sent to the client
+func RecoverMiddleware() Middleware {
+	return func(next Handler) Handler {
+		return func(call *Call) {
+			defer func() {
+				if r := recover(); r != nil {
+					fmt.Println("goal: panic serving", call.Request.URL.Path, r)
+					fmt.Println(string(debug.Stack()))
+
+					call.Code, call.Result, call.Err = http.StatusInternalServerError, nil, ErrInternal
+					if id := RequestID(call.Request); id != "" {
+						call.Result = map[string]string{"request_id": id}
+					}
+				}
+			}()
+
+			next(call)
+		}
+	}
+}
+
+// ErrBodyTooLarge is returned when request body exceeds the limit
+var ErrBodyTooLarge = errors.New("request body too large")
+
+// limitedBody fails reads past the limit
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+	exceeded  bool
+}
+
+func (body *limitedBody) Read(p []byte) (int, error) {
+	if body.remaining <= 0 {
+		// Only fail if there is more data to read
+		var b [1]byte
+		n, err := body.ReadCloser.Read(b[:])
+		if n > 0 {
+			body.exceeded = true
+			return 0, ErrBodyTooLarge
+		}
+		return 0, err
+	}
+
+	if int64(len(p)) > body.remaining {
+		p = p[:body.remaining]
+	}
+
+	n, err := body.ReadCloser.Read(p)
+	body.remaining -= int64(n)
+	return n, err
+}
+
+// BodyLimitMiddleware rejects request bodies larger than limit bytes
+// with 413 status
+func BodyLimitMiddleware(limit int64) Middleware {
+	return func(next Handler) Handler {
+		return func(call *Call) {
+			if call.Request.ContentLength > limit {
+				call.Code, call.Err = http.StatusRequestEntityTooLarge, ErrBodyTooLarge
+				return
+			}
+
+			if call.Request.Body == nil {
+				next(call)
+				return
+			}
+
+			body := &limitedBody{ReadCloser: call.Request.Body, remaining: limit}
+			call.Request.Body = body
+
+			next(call)
+
+			if body.exceeded {
+				call.Code, call.Result, call.Err = http.StatusRequestEntityTooLarge, nil, ErrBodyTooLarge
+			}
+		}
+	}
+}
+
+// CORSOptions configures CORSMiddleware
+type CORSOptions struct {
+	// AllowedOrigins lists origins allowed to call the API, "*" allows all
+	AllowedOrigins []string
+
+	// AllowedMethods default to GET, POST, PUT, PATCH and DELETE
+	AllowedMethods []string
+
+	// AllowedHeaders default to Content-Type, X-Request-ID and
+	// X-Goal-API-Key
+	AllowedHeaders []string
+
+	// ExposedHeaders are readable by browser scripts
+	ExposedHeaders []string
+
+	// AllowCredentials lets browsers send cookies. It is ignored if
+	// AllowedOrigins has "*", so any site can't make requests with
+	// the credentials of the user
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+func (options *CORSOptions) allowOrigin(origin string) bool {
+	for _, allowed := range options.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (options *CORSOptions) allowsAnyOrigin() bool {
+	for _, allowed := range options.AllowedOrigins {
+		if allowed == "*" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CORSMiddleware adds CORS headers for allowed origins and answers
+// preflight requests with 204 status
+func CORSMiddleware(options CORSOptions) Middleware {
+	methods := options.AllowedMethods
+	if len(methods) == 0 {
+		methods = []string{GET, POST, PUT, PATCH, DELETE}
+	}
+
+	headers := options.AllowedHeaders
+	if len(headers) == 0 {
+		headers = []string{"Content-Type", RequestIDHeader, APIKeyHeader}
+	}
+
+	// Any origin gets literal "*", which browsers never combine
+	// with credentials
+	anyOrigin := options.allowsAnyOrigin()
+	credentials := options.AllowCredentials && !anyOrigin
+
+	return func(next Handler) Handler {
+		return func(call *Call) {
+			origin := call.Request.Header.Get("Origin")
+			header := call.Writer.Header()
+			header.Add("Vary", "Origin")
+
+			if origin == "" || !options.allowOrigin(origin) {
+				next(call)
+				return
+			}
+
+			if anyOrigin {
+				header.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				header.Set("Access-Control-Allow-Origin", origin)
+			}
+
+			if credentials {
+				header.Set("Access-Control-Allow-Credentials", "true")
+			}
+
+			// Preflight request
+			if call.Request.Method == "OPTIONS" && call.Request.Header.Get("Access-Control-Request-Method") != "" {
+				header.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+				header.Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+				if options.MaxAge > 0 {
+					header.Set("Access-Control-Max-Age", strconv.Itoa(int(options.MaxAge/time.Second)))
+				}
+
+				call.Code = http.StatusNoContent
+				return
+			}
+
+			if len(options.ExposedHeaders) > 0 {
+				header.Set("Access-Control-Expose-Headers", strings.Join(options.ExposedHeaders, ", "))
+			}
+
+			next(call)
+		}
+	}
+}
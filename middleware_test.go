@@ -0,0 +1,129 @@
+package goal_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/thomasdao/goal"
+)
+
+type panicker struct{}
+
+func (p *panicker) Get(w http.ResponseWriter, request *http.Request) (int, interface{}, error) {
+	panic("something went wrong")
+}
+
+func TestMiddleware(t *testing.T) {
+	setup()
+	defer tearDown()
+
+	api := &goal.API{}
+	api.AddDefaultCrudPaths(&testuser{})
+	api.AddCrudResource(&panicker{}, "/panic")
+
+	var calls []*goal.Call
+	api.Use(
+		goal.RequestIDMiddleware(),
+		goal.RecoverMiddleware(),
+		goal.BodyLimitMiddleware(64),
+		goal.CORSMiddleware(goal.CORSOptions{AllowedOrigins: []string{"https://example.com"}}),
+		func(next goal.Handler) goal.Handler {
+			return func(call *goal.Call) {
+				next(call)
+				calls = append(calls, call)
+			}
+		},
+	)
+
+	send := func(req *http.Request) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		api.Mux().ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	// Operation, resource and result are visible to middlewares
+	req, _ := http.NewRequest("POST", "/testuser", strings.NewReader(`{"Name":"Thomas"}`))
+	res := send(req)
+	if res.Code != 200 || res.Header().Get("Content-Type") != "application/json" {
+		t.Fatal("Fail to create user", res.Code, res.Header())
+	}
+
+	if len(calls) != 1 || calls[0].Operation != goal.OperationCreate || calls[0].Result == nil {
+		t.Fatal("Middleware should see the call", calls)
+	}
+
+	if _, ok := calls[0].Resource.(*testuser); !ok {
+		t.Error("Resource should be the registered model", calls[0].Resource)
+	}
+
+	// Request id is generated or reused
+	if res.Header().Get(goal.RequestIDHeader) == "" {
+		t.Error("Request id should be generated")
+	}
+
+	req, _ = http.NewRequest("GET", "/testuser/1", nil)
+	req.Header.Set(goal.RequestIDHeader, "abc-123")
+	if id := send(req).Header().Get(goal.RequestIDHeader); id != "abc-123" {
+		t.Error("Request id should be reused", id)
+	}
+
+	// Panic becomes a json error
+	req, _ = http.NewRequest("GET", "/panic", nil)
+	res = send(req)
+	if res.Code != 500 || !strings.Contains(res.Body.String(), "internal server error") {
+		t.Error("Panic should return json error", res.Code, res.Body)
+	}
+
+	if strings.Contains(res.Body.String(), "something went wrong") {
+		t.Error("Panic value should not be sent to client")
+	}
+
+	// Body limit
+	body := `{"Name":"` + strings.Repeat("a", 100) + `"}`
+	req, _ = http.NewRequest("POST", "/testuser", strings.NewReader(body))
+	if res = send(req); res.Code != 413 {
+		t.Error("Large body should be rejected", res.Code)
+	}
+
+	req, _ = http.NewRequest("POST", "/testuser", strings.NewReader(body))
+	req.ContentLength = -1
+	if res = send(req); res.Code != 413 {
+		t.Error("Large body of unknown length should be rejected", res.Code)
+	}
+
+	// CORS preflight
+	req, _ = http.NewRequest("OPTIONS", "/testuser/1", nil)
+	req.Header.Set("Origin", "https://example.com")
+	req.Header.Set("Access-Control-Request-Method", "PUT")
+	res = send(req)
+	if res.Code != 204 || res.Header().Get("Access-Control-Allow-Origin") != "https://example.com" {
+		t.Error("Preflight should be allowed", res.Code, res.Header())
+	}
+
+	req, _ = http.NewRequest("GET", "/testuser/1", nil)
+	req.Header.Set("Origin", "https://evil.com")
+	if res = send(req); res.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Error("Unknown origin should not be allowed")
+	}
+}
+
+func TestCORSAnyOriginWithoutCredentials(t *testing.T) {
+	cors := goal.CORSMiddleware(goal.CORSOptions{AllowedOrigins: []string{"*"}, AllowCredentials: true})
+	handler := cors(func(call *goal.Call) {})
+
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/testuser/1", nil)
+	req.Header.Set("Origin", "https://evil.com")
+	handler(&goal.Call{Writer: recorder, Request: req})
+
+	// Origin is not reflected, so browsers don't send cookies
+	if origin := recorder.Header().Get("Access-Control-Allow-Origin"); origin != "*" {
+		t.Error("Any origin should be allowed with literal *", origin)
+	}
+
+	if recorder.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Error("Credentials should not be allowed for any origin")
+	}
+}
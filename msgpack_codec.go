@@ -0,0 +1,364 @@
+package goal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// msgpackCodec encodes values in MessagePack. Values are converted
+// through their json form first, so field names, tags and custom
+// marshalers behave the same as with JSONCodec
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string {
+	return "msgpack"
+}
+
+func (msgpackCodec) Marshal(val interface{}) ([]byte, error) {
+	data, err := json.Marshal(val)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var generic interface{}
+	err = decoder.Decode(&generic)
+	if err != nil {
+		return nil, err
+	}
+
+	var buffer bytes.Buffer
+	err = writeMsgpack(&buffer, generic)
+	return buffer.Bytes(), err
+}
+
+func (msgpackCodec) Unmarshal(data []byte, val interface{}) error {
+	reader := &msgpackReader{data: data}
+	generic, err := reader.read()
+	if err != nil {
+		return err
+	}
+
+	if reader.pos != len(data) {
+		return errors.New("msgpack: trailing data")
+	}
+
+	converted, err := json.Marshal(generic)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(converted, val)
+}
+
+// writeMsgpack encodes values produced by decoding json with UseNumber
+func writeMsgpack(buffer *bytes.Buffer, value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		buffer.WriteByte(0xc0)
+	case bool:
+		if v {
+			buffer.WriteByte(0xc3)
+		} else {
+			buffer.WriteByte(0xc2)
+		}
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			writeMsgpackInt(buffer, n)
+			return nil
+		}
+
+		if n, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			buffer.WriteByte(0xcf)
+			binary.Write(buffer, binary.BigEndian, n)
+			return nil
+		}
+
+		f, err := v.Float64()
+		if err != nil {
+			return err
+		}
+
+		buffer.WriteByte(0xcb)
+		binary.Write(buffer, binary.BigEndian, math.Float64bits(f))
+	case string:
+		n := len(v)
+		switch {
+		case n < 32:
+			buffer.WriteByte(0xa0 | byte(n))
+		case n <= math.MaxUint8:
+			buffer.Write([]byte{0xd9, byte(n)})
+		case n <= math.MaxUint16:
+			buffer.WriteByte(0xda)
+			binary.Write(buffer, binary.BigEndian, uint16(n))
+		default:
+			buffer.WriteByte(0xdb)
+			binary.Write(buffer, binary.BigEndian, uint32(n))
+		}
+		buffer.WriteString(v)
+	case []interface{}:
+		writeMsgpackLength(buffer, len(v), 0x90, 0xdc, 0xdd)
+		for _, item := range v {
+			err := writeMsgpack(buffer, item)
+			if err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		writeMsgpackLength(buffer, len(v), 0x80, 0xde, 0xdf)
+		for _, key := range keys {
+			writeMsgpack(buffer, key)
+			err := writeMsgpack(buffer, v[key])
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("msgpack: unsupported type %T", value)
+	}
+
+	return nil
+}
+
+func writeMsgpackInt(buffer *bytes.Buffer, n int64) {
+	switch {
+	case n >= 0 && n <= 127:
+		buffer.WriteByte(byte(n))
+	case n < 0 && n >= -32:
+		buffer.WriteByte(byte(int8(n)))
+	case n >= math.MinInt8 && n <= math.MaxInt8:
+		buffer.Write([]byte{0xd0, byte(int8(n))})
+	case n >= math.MinInt16 && n <= math.MaxInt16:
+		buffer.WriteByte(0xd1)
+		binary.Write(buffer, binary.BigEndian, int16(n))
+	case n >= math.MinInt32 && n <= math.MaxInt32:
+		buffer.WriteByte(0xd2)
+		binary.Write(buffer, binary.BigEndian, int32(n))
+	default:
+		buffer.WriteByte(0xd3)
+		binary.Write(buffer, binary.BigEndian, n)
+	}
+}
+
+// writeMsgpackLength writes header of an array or a map
+func writeMsgpackLength(buffer *bytes.Buffer, n int, fix byte, size16 byte, size32 byte) {
+	switch {
+	case n < 16:
+		buffer.WriteByte(fix | byte(n))
+	case n <= math.MaxUint16:
+		buffer.WriteByte(size16)
+		binary.Write(buffer, binary.BigEndian, uint16(n))
+	default:
+		buffer.WriteByte(size32)
+		binary.Write(buffer, binary.BigEndian, uint32(n))
+	}
+}
+
+var (
+	errMsgpackShort = errors.New("msgpack: unexpected end of data")
+	errMsgpackDepth = errors.New("msgpack: exceeded max depth")
+)
+
+// maxMsgpackDepth limits nesting of arrays and maps, the same limit
+// encoding/json applies, so crafted entries can't exhaust the stack
+const maxMsgpackDepth = 10000
+
+// msgpackReader decodes MessagePack into nil, bool, int64, uint64,
+// float64, string, []byte, []interface{} and map[string]interface{}
+type msgpackReader struct {
+	data  []byte
+	pos   int
+	depth int
+}
+
+func (reader *msgpackReader) next(n int) ([]byte, error) {
+	if n < 0 || reader.pos+n > len(reader.data) {
+		return nil, errMsgpackShort
+	}
+
+	b := reader.data[reader.pos : reader.pos+n]
+	reader.pos += n
+	return b, nil
+}
+
+// uint reads a big endian unsigned integer of n bytes
+func (reader *msgpackReader) uint(n int) (uint64, error) {
+	b, err := reader.next(n)
+	if err != nil {
+		return 0, err
+	}
+
+	var value uint64
+	for _, c := range b {
+		value = value<<8 | uint64(c)
+	}
+
+	return value, nil
+}
+
+func (reader *msgpackReader) read() (interface{}, error) {
+	b, err := reader.next(1)
+	if err != nil {
+		return nil, err
+	}
+
+	c := b[0]
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xe0 == 0xa0:
+		return reader.str(int(c & 0x1f))
+	case c&0xf0 == 0x90:
+		return reader.array(int(c & 0x0f))
+	case c&0xf0 == 0x80:
+		return reader.dict(int(c & 0x0f))
+	}
+
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := reader.uint(1 << (c - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := reader.next(int(n))
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{}, data...), nil
+	case 0xca:
+		bits, err := reader.uint(4)
+		return float64(math.Float32frombits(uint32(bits))), err
+	case 0xcb:
+		bits, err := reader.uint(8)
+		return math.Float64frombits(bits), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return reader.uint(1 << (c - 0xcc))
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (c - 0xd0)
+		value, err := reader.uint(size)
+		if err != nil {
+			return nil, err
+		}
+
+		// Sign extend
+		shift := uint(64 - 8*size)
+		return int64(value<<shift) >> shift, nil
+	case 0xd9, 0xda, 0xdb:
+		n, err := reader.uint(1 << (c - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return reader.str(int(n))
+	case 0xdc, 0xdd:
+		n, err := reader.uint(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return reader.array(int(n))
+	case 0xde, 0xdf:
+		n, err := reader.uint(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return reader.dict(int(n))
+	}
+
+	return nil, fmt.Errorf("msgpack: unsupported format 0x%x", c)
+}
+
+// nest checks room for n items, each taking at least one byte, and
+// depth of an array or map. Call leave once it is read
+func (reader *msgpackReader) nest(n int) error {
+	if n < 0 || n > len(reader.data)-reader.pos {
+		return errMsgpackShort
+	}
+
+	reader.depth++
+	if reader.depth > maxMsgpackDepth {
+		return errMsgpackDepth
+	}
+
+	return nil
+}
+
+func (reader *msgpackReader) leave() {
+	reader.depth--
+}
+
+func (reader *msgpackReader) str(n int) (interface{}, error) {
+	data, err := reader.next(n)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(data), nil
+}
+
+func (reader *msgpackReader) array(n int) (interface{}, error) {
+	if err := reader.nest(n); err != nil {
+		return nil, err
+	}
+	defer reader.leave()
+
+	items := make([]interface{}, n)
+	for i := range items {
+		item, err := reader.read()
+		if err != nil {
+			return nil, err
+		}
+		items[i] = item
+	}
+
+	return items, nil
+}
+
+func (reader *msgpackReader) dict(n int) (interface{}, error) {
+	if err := reader.nest(n); err != nil {
+		return nil, err
+	}
+	defer reader.leave()
+
+	values := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		key, err := reader.read()
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := reader.read()
+		if err != nil {
+			return nil, err
+		}
+
+		if s, ok := key.(string); ok {
+			values[s] = value
+		} else {
+			values[fmt.Sprint(key)] = value
+		}
+	}
+
+	return values, nil
+}
@@ -13,6 +13,8 @@ import "github.com/gorilla/mux"
 type API struct {
 	mux            *mux.Router
 	muxInitialized bool
+	masterKey      string
+	middlewares    []Middleware
 }
 
 var sharedAPI *API
@@ -0,0 +1,484 @@
+package goal
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// OAuthSessionName is the session which keeps state, nonce and PKCE
+// verifier between login redirect and callback
+const OAuthSessionName = "goal.OAuthSessionName"
+
+// OAuthProvider defines an OAuth2/OpenID Connect identity provider,
+// e.g. Google. Users sign in with authorization code flow and PKCE,
+// their identity is taken from the verified id_token
+type OAuthProvider struct {
+	Name         string
+	ClientID     string
+	ClientSecret string
+	AuthURL      string
+	TokenURL     string
+	JWKSURL      string
+	Issuer       string
+	RedirectURL  string
+	Scopes       []string
+
+	// PopulateUser is called before a new user signing in with
+	// this provider is saved, to copy claims like email into user
+	PopulateUser func(user interface{}, claims map[string]interface{}) error
+
+	// HTTPClient is used to call token and JWKS endpoints,
+	// default to http.DefaultClient
+	HTTPClient *http.Client
+
+	keys  map[string]*rsa.PublicKey
+	mutex sync.Mutex
+}
+
+var oauthProviders = make(map[string]*OAuthProvider)
+
+// RegisterOAuthProvider enables login with the provider, routed by
+// its name, e.g. /auth/oauth/google/login
+func RegisterOAuthProvider(provider *OAuthProvider) {
+	oauthProviders[provider.Name] = provider
+}
+
+func oauthProvider(request *http.Request) (*OAuthProvider, error) {
+	name := mux.Vars(request)["provider"]
+	provider, ok := oauthProviders[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown provider: %s", name)
+	}
+
+	return provider, nil
+}
+
+func (provider *OAuthProvider) client() *http.Client {
+	if provider.HTTPClient != nil {
+		return provider.HTTPClient
+	}
+
+	return http.DefaultClient
+}
+
+// randomString returns url safe random string with n bytes of entropy
+func randomString(n int) (string, error) {
+	data := make([]byte, n)
+	_, err := rand.Read(data)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// pkceChallenge derives S256 code challenge from the verifier
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// authCodeURL saves state, nonce and PKCE verifier into session and
+// returns the URL to redirect user to
+func (provider *OAuthProvider) authCodeURL(w http.ResponseWriter, request *http.Request, link bool) (string, error) {
+	session, err := SharedSessionStore.Get(request, OAuthSessionName)
+	if err != nil {
+		return "", err
+	}
+
+	state, err := randomString(32)
+	if err != nil {
+		return "", err
+	}
+
+	nonce, err := randomString(32)
+	if err != nil {
+		return "", err
+	}
+
+	verifier, err := randomString(32)
+	if err != nil {
+		return "", err
+	}
+
+	session.Values["provider"] = provider.Name
+	session.Values["state"] = state
+	session.Values["nonce"] = nonce
+	session.Values["verifier"] = verifier
+	session.Values["link"] = link
+
+	err = session.Save(request, w)
+	if err != nil {
+		return "", err
+	}
+
+	scopes := provider.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid"}
+	}
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", provider.ClientID)
+	params.Set("redirect_uri", provider.RedirectURL)
+	params.Set("scope", strings.Join(scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", pkceChallenge(verifier))
+	params.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(provider.AuthURL, "?") {
+		separator = "&"
+	}
+
+	return provider.AuthURL + separator + params.Encode(), nil
+}
+
+// oauthState is loaded from session in the callback
+type oauthState struct {
+	nonce    string
+	verifier string
+	link     bool
+}
+
+// consumeState validates state parameter of the callback, the state
+// is removed from session so it can only be used once
+func (provider *OAuthProvider) consumeState(w http.ResponseWriter, request *http.Request) (*oauthState, error) {
+	session, err := SharedSessionStore.Get(request, OAuthSessionName)
+	if err != nil {
+		return nil, err
+	}
+
+	expected, _ := session.Values["state"].(string)
+	name, _ := session.Values["provider"].(string)
+	state := &oauthState{}
+	state.nonce, _ = session.Values["nonce"].(string)
+	state.verifier, _ = session.Values["verifier"].(string)
+	state.link, _ = session.Values["link"].(bool)
+
+	for _, key := range []string{"provider", "state", "nonce", "verifier", "link"} {
+		delete(session.Values, key)
+	}
+
+	err = session.Save(request, w)
+	if err != nil {
+		return nil, err
+	}
+
+	actual := request.URL.Query().Get("state")
+	if expected == "" || name != provider.Name ||
+		subtle.ConstantTimeCompare([]byte(expected), []byte(actual)) != 1 {
+		return nil, errors.New("invalid oauth state")
+	}
+
+	return state, nil
+}
+
+// exchange trades authorization code for tokens and returns
+// the raw id_token
+func (provider *OAuthProvider) exchange(code string, verifier string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", provider.RedirectURL)
+	form.Set("client_id", provider.ClientID)
+	form.Set("code_verifier", verifier)
+	if provider.ClientSecret != "" {
+		form.Set("client_secret", provider.ClientSecret)
+	}
+
+	resp, err := provider.client().PostForm(provider.TokenURL, form)
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint returned %d", resp.StatusCode)
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&tokens)
+	if err != nil {
+		return "", err
+	}
+
+	if tokens.IDToken == "" {
+		return "", errors.New("id_token is missing")
+	}
+
+	return tokens.IDToken, nil
+}
+
+// publicKey returns the signing key, JWKS is fetched again when
+// the key is not known, e.g. after provider rotates its keys
+func (provider *OAuthProvider) publicKey(kid string) (*rsa.PublicKey, error) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	if key, ok := provider.keys[kid]; ok {
+		return key, nil
+	}
+
+	resp, err := provider.client().Get(provider.JWKSURL)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("jwks endpoint returned %d", resp.StatusCode)
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&jwks)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, jwk := range jwks.Keys {
+		if jwk.Kty != "RSA" {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			continue
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			continue
+		}
+
+		keys[jwk.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	provider.keys = keys
+
+	key, ok := keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key: %s", kid)
+	}
+
+	return key, nil
+}
+
+// verifyIDToken checks signature, issuer, audience, expiry and nonce
+// of the id_token and returns its claims
+func (provider *OAuthProvider) verifyIDToken(token string, nonce string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed id_token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &header)
+	if err != nil {
+		return nil, err
+	}
+
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("unsupported id_token algorithm: %s", header.Alg)
+	}
+
+	key, err := provider.publicKey(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	err = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature)
+	if err != nil {
+		return nil, errors.New("invalid id_token signature")
+	}
+
+	data, err = base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	err = json.Unmarshal(data, &claims)
+	if err != nil {
+		return nil, err
+	}
+
+	if provider.Issuer != "" && claims["iss"] != provider.Issuer {
+		return nil, errors.New("invalid id_token issuer")
+	}
+
+	if !audienceContains(claims["aud"], provider.ClientID) {
+		return nil, errors.New("invalid id_token audience")
+	}
+
+	exp, _ := claims["exp"].(float64)
+	if time.Now().Unix() >= int64(exp) {
+		return nil, errors.New("id_token is expired")
+	}
+
+	if claims["nonce"] != nonce {
+		return nil, errors.New("invalid id_token nonce")
+	}
+
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, errors.New("id_token subject is missing")
+	}
+
+	return claims, nil
+}
+
+// audienceContains checks aud claim, which can be a string or an array
+func audienceContains(aud interface{}, clientID string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == clientID
+	case []interface{}:
+		for _, item := range aud {
+			if item == clientID {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// redirectToProvider starts login or account linking flow
+func (api *API) redirectToProvider(link bool) http.HandlerFunc {
+	return func(rw http.ResponseWriter, request *http.Request) {
+		provider, err := oauthProvider(request)
+		if err != nil {
+			http.Error(rw, getErrorString(nil, err), http.StatusNotFound)
+			return
+		}
+
+		if link {
+			user, err := GetCurrentUser(request)
+			if err != nil || user == nil {
+				http.Error(rw, getErrorString(nil, ErrNotLoggedIn), http.StatusUnauthorized)
+				return
+			}
+		}
+
+		location, err := provider.authCodeURL(rw, request, link)
+		if err != nil {
+			http.Error(rw, getErrorString(nil, err), http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(rw, request, location, http.StatusFound)
+	}
+}
+
+// HandleOAuthCallback verifies the authorization response, then
+// signs the user in or links the identity to current user
+func HandleOAuthCallback(w http.ResponseWriter, request *http.Request) (int, interface{}, error) {
+	provider, err := oauthProvider(request)
+	if err != nil {
+		return 404, nil, err
+	}
+
+	state, err := provider.consumeState(w, request)
+	if err != nil {
+		return 400, nil, err
+	}
+
+	query := request.URL.Query()
+	if errCode := query.Get("error"); errCode != "" {
+		return 400, nil, fmt.Errorf("provider returned error: %s", errCode)
+	}
+
+	idToken, err := provider.exchange(query.Get("code"), state.verifier)
+	if err != nil {
+		return 401, nil, err
+	}
+
+	claims, err := provider.verifyIDToken(idToken, state.nonce)
+	if err != nil {
+		return 401, nil, err
+	}
+
+	subject := claims["sub"].(string)
+	if state.link {
+		return linkOAuthIdentity(request, provider, subject)
+	}
+
+	return loginWithOAuthIdentity(w, request, provider, subject, claims)
+}
+
+// HandleOAuthUnlink removes the provider identity of current user
+func HandleOAuthUnlink(w http.ResponseWriter, request *http.Request) (int, interface{}, error) {
+	if request.Method != POST && request.Method != DELETE {
+		return 405, nil, http.ErrNotSupported
+	}
+
+	provider, err := oauthProvider(request)
+	if err != nil {
+		return 404, nil, err
+	}
+
+	return unlinkOAuthIdentity(request, provider)
+}
+
+// AddDefaultOAuthPaths routes login, link, callback and unlink
+// requests of registered OAuthProvider
+func (api *API) AddDefaultOAuthPaths() {
+	db.AutoMigrate(&AuthData{})
+
+	api.Mux().Handle("/auth/oauth/{provider}/login", api.redirectToProvider(false))
+	api.Mux().Handle("/auth/oauth/{provider}/link", api.redirectToProvider(true))
+	api.Mux().HandleFunc("/auth/oauth/{provider}/callback", func(rw http.ResponseWriter, request *http.Request) {
+		api.renderJSON(rw, request, nil, OperationAuth, HandleOAuthCallback)
+	})
+	api.Mux().HandleFunc("/auth/oauth/{provider}/unlink", func(rw http.ResponseWriter, request *http.Request) {
+		api.renderJSON(rw, request, nil, OperationAuth, HandleOAuthUnlink)
+	})
+}
@@ -0,0 +1,196 @@
+package goal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// OAuthPasswordColumn is the password column of the user model. Users
+// with an empty password can't unlink their last OAuth identity
+var OAuthPasswordColumn = "password"
+
+// ErrLastLoginMethod is returned when unlinking the only identity of a
+// user without password
+var ErrLastLoginMethod = errors.New("identity is the only way to log in")
+
+// AuthData links a user to an identity of an OAuth provider
+type AuthData struct {
+	ID        uint   `gorm:"primary_key" json:"id"`
+	UserID    string `gorm:"index" json:"user_id"`
+	Provider  string `gorm:"unique_index:idx_auth_data_identity" json:"provider"`
+	Subject   string `gorm:"unique_index:idx_auth_data_identity" json:"subject"`
+	CreatedAt time.Time
+}
+
+// TableName conforms to gorm naming interface
+func (data *AuthData) TableName() string {
+	return "auth_data"
+}
+
+// userID returns primary key of user as string
+func userID(user interface{}) string {
+	return fmt.Sprint(db.NewScope(user).PrimaryKeyValue())
+}
+
+// loadUserByID loads the user model by primary key
+func loadUserByID(id string) (interface{}, error) {
+	user, err := getUserResource()
+	if err != nil {
+		return nil, err
+	}
+
+	scope := db.NewScope(user)
+	qry := fmt.Sprintf("%s = ?", scope.Quote(scope.PrimaryKey()))
+	err = db.Where(qry, id).First(user).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func findAuthData(provider string, subject string) (*AuthData, error) {
+	data := &AuthData{}
+	err := db.Where("provider = ? AND subject = ?", provider, subject).First(data).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// loginWithOAuthIdentity signs in the user linked to the identity,
+// or creates a new user for it
+func loginWithOAuthIdentity(
+	w http.ResponseWriter, request *http.Request, provider *OAuthProvider,
+	subject string, claims map[string]interface{}) (int, interface{}, error) {
+	data, err := findAuthData(provider.Name, subject)
+	if err != nil {
+		return 500, nil, err
+	}
+
+	var user interface{}
+	if data != nil {
+		user, err = loadUserByID(data.UserID)
+		if err != nil {
+			return 500, nil, err
+		}
+	} else {
+		user, err = getUserResource()
+		if err != nil {
+			return 500, nil, err
+		}
+
+		if provider.PopulateUser != nil {
+			err = provider.PopulateUser(user, claims)
+			if err != nil {
+				return 400, nil, err
+			}
+		}
+
+		tx := db.Begin()
+		err = tx.Create(user).Error
+		if err == nil {
+			data = &AuthData{UserID: userID(user), Provider: provider.Name, Subject: subject}
+			err = tx.Create(data).Error
+		}
+
+		if err != nil {
+			tx.Rollback()
+			return 500, nil, err
+		}
+
+		err = tx.Commit().Error
+		if err != nil {
+			return 500, nil, err
+		}
+	}
+
+	err = SetUserSession(w, request, user)
+	if err != nil {
+		return 500, nil, err
+	}
+
+	RedactFields(user, user)
+	return 200, user, nil
+}
+
+// linkOAuthIdentity links the identity to current user
+func linkOAuthIdentity(request *http.Request, provider *OAuthProvider, subject string) (int, interface{}, error) {
+	user, err := GetCurrentUser(request)
+	if err != nil || user == nil {
+		return 401, nil, ErrNotLoggedIn
+	}
+
+	data, err := findAuthData(provider.Name, subject)
+	if err != nil {
+		return 500, nil, err
+	}
+
+	id := userID(user)
+	if data != nil {
+		if data.UserID != id {
+			return 409, nil, errors.New("identity is linked to another account")
+		}
+
+		return 200, data, nil
+	}
+
+	data = &AuthData{UserID: id, Provider: provider.Name, Subject: subject}
+	err = db.Create(data).Error
+	if err != nil {
+		return 500, nil, err
+	}
+
+	return 200, data, nil
+}
+
+// hasPassword checks if the user has a password to log in with
+func hasPassword(ctx context.Context, user interface{}) bool {
+	if OAuthPasswordColumn == "" || !db.NewScope(user).HasColumn(OAuthPasswordColumn) {
+		return false
+	}
+
+	hashed, err := storedPasswordHash(ctx, user, OAuthPasswordColumn)
+	return err == nil && hashed != ""
+}
+
+// unlinkOAuthIdentity removes identities of the provider from current user
+func unlinkOAuthIdentity(request *http.Request, provider *OAuthProvider) (int, interface{}, error) {
+	user, err := GetCurrentUser(request)
+	if err != nil || user == nil {
+		return 401, nil, ErrNotLoggedIn
+	}
+
+	// Keep at least one way to log in
+	ctx := request.Context()
+	var others int
+	err = contextDB(ctx).Model(&AuthData{}).Where("user_id = ? AND provider <> ?", userID(user), provider.Name).Count(&others).Error
+	if err != nil {
+		return 500, nil, err
+	}
+
+	if others == 0 && !hasPassword(ctx, user) {
+		return 409, nil, ErrLastLoginMethod
+	}
+
+	qry := contextDB(ctx).Where("user_id = ? AND provider = ?", userID(user), provider.Name).Delete(&AuthData{})
+	if qry.Error != nil {
+		return 500, nil, qry.Error
+	}
+
+	if qry.RowsAffected == 0 {
+		return 404, nil, errors.New("identity is not linked")
+	}
+
+	return 200, nil, nil
+}
@@ -0,0 +1,232 @@
+package goal_test
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/thomasdao/goal"
+)
+
+// stubOIDC is a minimal OpenID Connect provider
+type stubOIDC struct {
+	server    *httptest.Server
+	key       *rsa.PrivateKey
+	subject   string
+	nonce     string
+	challenge string
+}
+
+func newStubOIDC(t *testing.T) *stubOIDC {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stub := &stubOIDC{key: key}
+	router := http.NewServeMux()
+
+	router.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "stub-key",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+
+	router.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+		if r.Form.Get("code") != "good-code" ||
+			base64.RawURLEncoding.EncodeToString(sum[:]) != stub.challenge {
+			http.Error(w, `{"error":"invalid_grant"}`, 400)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "access",
+			"id_token":     stub.idToken(t),
+		})
+	})
+
+	stub.server = httptest.NewServer(router)
+	return stub
+}
+
+func (stub *stubOIDC) idToken(t *testing.T) string {
+	encode := func(v interface{}) string {
+		data, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+
+	header := encode(map[string]string{"alg": "RS256", "kid": "stub-key"})
+	claims := encode(map[string]interface{}{
+		"iss":   stub.server.URL,
+		"aud":   "goal-client",
+		"sub":   stub.subject,
+		"nonce": stub.nonce,
+		"exp":   time.Now().Add(time.Minute).Unix(),
+	})
+
+	digest := sha256.Sum256([]byte(header + "." + claims))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, stub.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return header + "." + claims + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// startFlow calls login or link path, remembers nonce and PKCE
+// challenge like the real provider would, and returns state
+// with oauth session cookie
+func (stub *stubOIDC) startFlow(t *testing.T, path string, cookie string) (string, string) {
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", path, nil)
+	if cookie != "" {
+		req.Header.Add("Cookie", cookie)
+	}
+	goal.SharedAPI().Mux().ServeHTTP(recorder, req)
+
+	if recorder.Code != http.StatusFound {
+		t.Fatal("Should redirect to provider", recorder.Code, recorder.Body)
+	}
+
+	location, _ := url.Parse(recorder.Header().Get("Location"))
+	query := location.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("client_id") != "goal-client" {
+		t.Error("Invalid authorization request", location)
+	}
+
+	stub.nonce = query.Get("nonce")
+	stub.challenge = query.Get("code_challenge")
+
+	return query.Get("state"), recorder.Header().Get("Set-Cookie")
+}
+
+func callback(state string, cookies ...string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	path := "/auth/oauth/stub/callback?code=good-code&state=" + url.QueryEscape(state)
+	req, _ := http.NewRequest("GET", path, nil)
+	for _, cookie := range cookies {
+		req.Header.Add("Cookie", cookie)
+	}
+	goal.SharedAPI().Mux().ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestOAuthLogin(t *testing.T) {
+	setup()
+	defer tearDown()
+
+	stub := newStubOIDC(t)
+	defer stub.server.Close()
+
+	goal.RegisterOAuthProvider(&goal.OAuthProvider{
+		Name:        "stub",
+		ClientID:    "goal-client",
+		AuthURL:     stub.server.URL + "/authorize",
+		TokenURL:    stub.server.URL + "/token",
+		JWKSURL:     stub.server.URL + "/jwks",
+		Issuer:      stub.server.URL,
+		RedirectURL: "http://localhost/auth/oauth/stub/callback",
+		PopulateUser: func(user interface{}, claims map[string]interface{}) error {
+			user.(*testuser).Name = claims["sub"].(string)
+			return nil
+		},
+	})
+	goal.SharedAPI().AddDefaultOAuthPaths()
+
+	// Sign in creates a new user linked to the identity
+	stub.subject = "stub-user-1"
+	state, oauthCookie := stub.startFlow(t, "/auth/oauth/stub/login", "")
+
+	res := callback("forged-state", oauthCookie)
+	if res.Code != 400 {
+		t.Error("Forged state should be rejected", res.Code)
+	}
+
+	state, oauthCookie = stub.startFlow(t, "/auth/oauth/stub/login", "")
+	res = callback(state, oauthCookie)
+	if res.Code != 200 {
+		t.Fatal("Fail to sign in with provider", res.Body)
+	}
+
+	var user testuser
+	if err := db.Where("name = ?", "stub-user-1").First(&user).Error; err != nil {
+		t.Fatal("User is not created", err)
+	}
+
+	// Only identity of a user without password can't be unlinked
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/auth/oauth/stub/unlink", nil)
+	for _, cookie := range res.Header()["Set-Cookie"] {
+		req.Header.Add("Cookie", cookie)
+	}
+	goal.SharedAPI().Mux().ServeHTTP(recorder, req)
+	if recorder.Code != 409 {
+		t.Error("Last login method should not be unlinked", recorder.Code, recorder.Body)
+	}
+
+	var data goal.AuthData
+	if err := db.Where("provider = ? AND subject = ?", "stub", "stub-user-1").First(&data).Error; err != nil {
+		t.Fatal("Identity is not linked", err)
+	}
+
+	// State can only be used once
+	res = callback(state, res.Header().Get("Set-Cookie"))
+	if res.Code != 400 {
+		t.Error("Replayed state should be rejected", res.Code)
+	}
+
+	// Link another identity to a password account
+	recorder = httptest.NewRecorder()
+	body := []byte(`{"username":"linker", "password": "secret-password"}`)
+	req, _ = http.NewRequest("POST", "/auth/register", bytes.NewBuffer(body))
+	goal.SharedAPI().Mux().ServeHTTP(recorder, req)
+	userCookie := recorder.Header().Get("Set-Cookie")
+
+	stub.subject = "stub-user-2"
+	state, oauthCookie = stub.startFlow(t, "/auth/oauth/stub/link", userCookie)
+	res = callback(state, oauthCookie, userCookie)
+	if res.Code != 200 {
+		t.Fatal("Fail to link identity", res.Body)
+	}
+
+	var linker testuser
+	db.Where("username = ?", "linker").First(&linker)
+	var linked goal.AuthData
+	db.Where("provider = ? AND subject = ?", "stub", "stub-user-2").First(&linked)
+	if linked.UserID != fmt.Sprint(linker.ID) {
+		t.Error("Identity is linked to wrong user", linked.UserID, linker.ID)
+	}
+
+	// Unlink
+	recorder = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/auth/oauth/stub/unlink", nil)
+	req.Header.Add("Cookie", userCookie)
+	goal.SharedAPI().Mux().ServeHTTP(recorder, req)
+	if recorder.Code != 200 {
+		t.Error("Fail to unlink identity", recorder.Body)
+	}
+
+	var count int
+	db.Model(&goal.AuthData{}).Where("subject = ?", "stub-user-2").Count(&count)
+	if count != 0 {
+		t.Error("Identity should be unlinked")
+	}
+}
@@ -0,0 +1,417 @@
+package goal
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordHasher hashes and verifies passwords with one algorithm
+type PasswordHasher interface {
+	// Hash returns encoded hash of the password
+	Hash(password string) (string, error)
+
+	// Compare returns nil if password matches the hash
+	Compare(hashed string, password string) error
+
+	// Identifies returns true if the hash was created by this algorithm
+	Identifies(hashed string) bool
+
+	// NeedsRehash returns true if the hash was created with outdated
+	// parameters, e.g. lower bcrypt cost
+	NeedsRehash(hashed string) bool
+}
+
+// BcryptHasher implements PasswordHasher with bcrypt
+type BcryptHasher struct {
+	Cost int
+}
+
+func (hasher *BcryptHasher) cost() int {
+	if hasher.Cost == 0 {
+		return bcrypt.DefaultCost
+	}
+
+	return hasher.Cost
+}
+
+// Hash conforms to PasswordHasher interface
+func (hasher *BcryptHasher) Hash(password string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), hasher.cost())
+	return string(hashed), err
+}
+
+// Compare conforms to PasswordHasher interface
+func (hasher *BcryptHasher) Compare(hashed string, password string) error {
+	return bcrypt.CompareHashAndPassword([]byte(hashed), []byte(password))
+}
+
+// Identifies conforms to PasswordHasher interface
+func (hasher *BcryptHasher) Identifies(hashed string) bool {
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$"} {
+		if strings.HasPrefix(hashed, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NeedsRehash conforms to PasswordHasher interface
+func (hasher *BcryptHasher) NeedsRehash(hashed string) bool {
+	cost, err := bcrypt.Cost([]byte(hashed))
+	return err != nil || cost != hasher.cost()
+}
+
+// Argon2idHasher implements PasswordHasher with argon2id. Hashes are
+// encoded as $argon2id$v=19$m=65536,t=1,p=4$salt$key
+type Argon2idHasher struct {
+	Time       uint32
+	Memory     uint32 // in KiB
+	Threads    uint8
+	KeyLength  uint32
+	SaltLength uint32
+}
+
+// NewArgon2idHasher returns Argon2idHasher with recommended parameters
+func NewArgon2idHasher() *Argon2idHasher {
+	return &Argon2idHasher{
+		Time:       1,
+		Memory:     64 * 1024,
+		Threads:    4,
+		KeyLength:  32,
+		SaltLength: 16,
+	}
+}
+
+// Bounds of parameters used to hash and accepted from stored hashes,
+// argon2 panics on zero time or threads and huge memory would
+// exhaust the process on every compare
+const (
+	maxArgon2idMemory = 1024 * 1024 // 1 GiB in KiB
+	maxArgon2idTime   = 64
+)
+
+// checkArgon2idParams returns an error if parameters are out of bounds
+func checkArgon2idParams(memory uint32, time uint32, threads uint8) error {
+	if time < 1 || time > maxArgon2idTime ||
+		threads < 1 ||
+		memory < 8*uint32(threads) || memory > maxArgon2idMemory {
+		return errors.New("argon2id parameters out of range")
+	}
+
+	return nil
+}
+
+// argon2idParams are decoded from an encoded hash
+type argon2idParams struct {
+	version int
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+func decodeArgon2id(hashed string) (*argon2idParams, error) {
+	parts := strings.Split(hashed, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, errors.New("invalid argon2id hash")
+	}
+
+	params := &argon2idParams{}
+	_, err := fmt.Sscanf(parts[2], "v=%d", &params.version)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads)
+	if err != nil {
+		return nil, err
+	}
+
+	params.salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, err
+	}
+
+	params.key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkArgon2idParams(params.memory, params.time, params.threads)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(params.key) == 0 {
+		return nil, errors.New("argon2id hash has no key")
+	}
+
+	return params, nil
+}
+
+// Hash conforms to PasswordHasher interface
+func (hasher *Argon2idHasher) Hash(password string) (string, error) {
+	err := checkArgon2idParams(hasher.Memory, hasher.Time, hasher.Threads)
+	if err != nil {
+		return "", err
+	}
+
+	if hasher.SaltLength == 0 || hasher.KeyLength == 0 {
+		return "", errors.New("argon2id salt and key length must not be zero")
+	}
+
+	salt := make([]byte, hasher.SaltLength)
+	_, err = rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, hasher.Time, hasher.Memory, hasher.Threads, hasher.KeyLength)
+
+	hashed := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, hasher.Memory, hasher.Time, hasher.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key))
+
+	return hashed, nil
+}
+
+// Compare conforms to PasswordHasher interface
+func (hasher *Argon2idHasher) Compare(hashed string, password string) error {
+	params, err := decodeArgon2id(hashed)
+	if err != nil {
+		return err
+	}
+
+	key := argon2.IDKey([]byte(password), params.salt, params.time, params.memory, params.threads, uint32(len(params.key)))
+	if subtle.ConstantTimeCompare(key, params.key) != 1 {
+		return errors.New("password does not match")
+	}
+
+	return nil
+}
+
+// Identifies conforms to PasswordHasher interface
+func (hasher *Argon2idHasher) Identifies(hashed string) bool {
+	return strings.HasPrefix(hashed, "$argon2id$")
+}
+
+// NeedsRehash conforms to PasswordHasher interface
+func (hasher *Argon2idHasher) NeedsRehash(hashed string) bool {
+	params, err := decodeArgon2id(hashed)
+	if err != nil {
+		return true
+	}
+
+	return params.version != argon2.Version ||
+		params.memory != hasher.Memory ||
+		params.time != hasher.Time ||
+		params.threads != hasher.Threads ||
+		uint32(len(params.key)) != hasher.KeyLength ||
+		uint32(len(params.salt)) != hasher.SaltLength
+}
+
+// SharedPasswordHasher hashes new passwords, default to bcrypt
+// with default cost
+var SharedPasswordHasher PasswordHasher = &BcryptHasher{}
+
+// knownHashers verify hashes created by algorithms other
+// than SharedPasswordHasher
+var knownHashers = []PasswordHasher{&BcryptHasher{}, NewArgon2idHasher()}
+
+// SetPasswordHasher replaces SharedPasswordHasher. Existing hashes are
+// still verified and transparently rehashed at next login
+func SetPasswordHasher(hasher PasswordHasher) {
+	SharedPasswordHasher = hasher
+}
+
+// hashPassword hashes password with SharedPasswordHasher
+func hashPassword(password string) (string, error) {
+	return SharedPasswordHasher.Hash(password)
+}
+
+// comparePassword verifies password against a hash created by any
+// known algorithm, and tells if the hash should be upgraded
+func comparePassword(hashed string, password string) (bool, error) {
+	hasher := SharedPasswordHasher
+	if !hasher.Identifies(hashed) {
+		hasher = nil
+		for _, known := range knownHashers {
+			if known.Identifies(hashed) {
+				hasher = known
+				break
+			}
+		}
+	}
+
+	if hasher == nil {
+		return false, errors.New("unknown password hash algorithm")
+	}
+
+	err := hasher.Compare(hashed, password)
+	if err != nil {
+		return false, err
+	}
+
+	rehash := !SharedPasswordHasher.Identifies(hashed) || SharedPasswordHasher.NeedsRehash(hashed)
+	return rehash, nil
+}
+
+// PasswordPolicy defines requirements for new passwords
+type PasswordPolicy struct {
+	MinLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+
+	breached map[string]bool
+}
+
+// PasswordPolicyError lists all requirements a password failed
+type PasswordPolicyError struct {
+	Violations []string
+}
+
+func (err *PasswordPolicyError) Error() string {
+	return "password is too weak: " + strings.Join(err.Violations, ", ")
+}
+
+// LoadBreachedPasswords reads a list of breached passwords from a local
+// file, one per line. A line can be plaintext password or its SHA-1
+// hash in hex, as published by https://haveibeenpwned.com
+func (policy *PasswordPolicy) LoadBreachedPasswords(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	if policy.breached == nil {
+		policy.breached = make(map[string]bool)
+	}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		// Ignore optional ":count" suffix of SHA-1 lists
+		if idx := strings.Index(line, ":"); idx == 40 {
+			line = line[:idx]
+		}
+
+		if line == "" {
+			continue
+		}
+
+		if isSHA1Hex(line) {
+			line = strings.ToUpper(line)
+		}
+
+		policy.breached[line] = true
+	}
+
+	return scanner.Err()
+}
+
+func isSHA1Hex(str string) bool {
+	if len(str) != 40 {
+		return false
+	}
+
+	_, err := hex.DecodeString(str)
+	return err == nil
+}
+
+// isBreached checks password against loaded breached passwords
+func (policy *PasswordPolicy) isBreached(password string) bool {
+	if policy.breached == nil {
+		return false
+	}
+
+	sum := sha1.Sum([]byte(password))
+	return policy.breached[password] || policy.breached[strings.ToUpper(hex.EncodeToString(sum[:]))]
+}
+
+// Validate returns PasswordPolicyError if password does not
+// satisfy the policy
+func (policy *PasswordPolicy) Validate(password string) error {
+	var violations []string
+
+	if len([]rune(password)) < policy.MinLength {
+		violations = append(violations, fmt.Sprintf("at least %d characters", policy.MinLength))
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+
+	if policy.RequireUpper && !upper {
+		violations = append(violations, "an uppercase letter")
+	}
+
+	if policy.RequireLower && !lower {
+		violations = append(violations, "a lowercase letter")
+	}
+
+	if policy.RequireDigit && !digit {
+		violations = append(violations, "a digit")
+	}
+
+	if policy.RequireSymbol && !symbol {
+		violations = append(violations, "a symbol")
+	}
+
+	if policy.isBreached(password) {
+		violations = append(violations, "not a known breached password")
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+
+	return nil
+}
+
+// SharedPasswordPolicy is checked when registering or changing
+// password, nil means any non-empty password is accepted
+var SharedPasswordPolicy *PasswordPolicy
+
+// SetPasswordPolicy replaces SharedPasswordPolicy
+func SetPasswordPolicy(policy *PasswordPolicy) {
+	SharedPasswordPolicy = policy
+}
+
+// validatePassword checks password against SharedPasswordPolicy
+func validatePassword(password string) error {
+	if SharedPasswordPolicy == nil {
+		return nil
+	}
+
+	return SharedPasswordPolicy.Validate(password)
+}
@@ -0,0 +1,147 @@
+package goal_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/thomasdao/goal"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestArgon2idHasher(t *testing.T) {
+	hasher := goal.NewArgon2idHasher()
+
+	hashed, err := hasher.Hash("secret-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !hasher.Identifies(hashed) || hasher.NeedsRehash(hashed) {
+		t.Error("Hash should be identified as current argon2id hash", hashed)
+	}
+
+	if hasher.Compare(hashed, "secret-password") != nil {
+		t.Error("Password should match")
+	}
+
+	if hasher.Compare(hashed, "wrong-password") == nil {
+		t.Error("Wrong password should not match")
+	}
+
+	// Malformed hashes from the database are rejected, not trusted
+	salt := "c2FsdHNhbHRzYWx0c2FsdA"
+	key := "a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5"
+	malformed := []string{
+		"$argon2id$v=19$m=65536,t=1,p=4$" + salt + "$",
+		"$argon2id$v=19$m=65536,t=0,p=4$" + salt + "$" + key,
+		"$argon2id$v=19$m=65536,t=1,p=0$" + salt + "$" + key,
+		"$argon2id$v=19$m=4294967295,t=1,p=4$" + salt + "$" + key,
+	}
+	for _, hash := range malformed {
+		if hasher.Compare(hash, "") == nil || hasher.Compare(hash, "secret-password") == nil {
+			t.Error("Malformed hash should not match", hash)
+		}
+	}
+
+	// Partly filled hashers are rejected instead of panicking
+	for _, partial := range []*goal.Argon2idHasher{
+		{},
+		{Memory: 65536},
+		{Memory: 65536, Time: 1, Threads: 4},
+		{Memory: 8 * 1024 * 1024, Time: 1, Threads: 4, SaltLength: 16, KeyLength: 32},
+	} {
+		if _, err := partial.Hash("secret-password"); err == nil {
+			t.Error("Hasher with invalid parameters should fail", partial)
+		}
+	}
+
+	stronger := goal.NewArgon2idHasher()
+	stronger.Time = 2
+	if !stronger.NeedsRehash(hashed) {
+		t.Error("Hash with outdated parameters should be rehashed")
+	}
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	file, err := ioutil.TempFile("", "breached")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+
+	// "Password1!" in plaintext and SHA-1 of "Letmein99?"
+	file.WriteString("Password1!\nb7479c0a03f70a286480d9f4831b94cab844cd2e:12\n")
+	file.Close()
+
+	policy := &goal.PasswordPolicy{
+		MinLength:     8,
+		RequireUpper:  true,
+		RequireLower:  true,
+		RequireDigit:  true,
+		RequireSymbol: true,
+	}
+
+	err = policy.LoadBreachedPasswords(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = policy.Validate("short"); err == nil {
+		t.Error("Weak password should be rejected")
+	} else if violations := err.(*goal.PasswordPolicyError).Violations; len(violations) != 4 {
+		t.Error("Unexpected violations", violations)
+	}
+
+	if policy.Validate("Password1!") == nil {
+		t.Error("Breached password should be rejected")
+	}
+
+	if policy.Validate("Letmein99?") == nil {
+		t.Error("Password matching a breached SHA-1 should be rejected")
+	}
+
+	if policy.Validate("Correct-Horse-7") != nil {
+		t.Error("Strong password should be accepted")
+	}
+}
+
+func TestRehashOnLogin(t *testing.T) {
+	setup()
+	defer tearDown()
+
+	hasher := goal.SharedPasswordHasher
+	defer goal.SetPasswordHasher(hasher)
+	goal.SetPasswordHasher(&goal.BcryptHasher{Cost: bcrypt.MinCost})
+
+	var json = []byte(`{"username":"rehash", "password": "secret-password"}`)
+	req, _ := http.NewRequest("POST", "/auth/register", bytes.NewBuffer(json))
+	goal.SharedAPI().Mux().ServeHTTP(httptest.NewRecorder(), req)
+
+	// Switch algorithm, next login should upgrade the stored hash
+	goal.SetPasswordHasher(goal.NewArgon2idHasher())
+
+	recorder := httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/auth/login", bytes.NewBuffer(json))
+	goal.SharedAPI().Mux().ServeHTTP(recorder, req)
+	if recorder.Code != 200 {
+		t.Fatal("Fail to login with outdated hash", recorder.Body)
+	}
+
+	var user testuser
+	db.Where("username = ?", "rehash").First(&user)
+	if !strings.HasPrefix(user.Password, "$argon2id$") {
+		t.Error("Password should be rehashed with argon2id", user.Password)
+	}
+
+	recorder = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/auth/login", bytes.NewBuffer(json))
+	goal.SharedAPI().Mux().ServeHTTP(recorder, req)
+	if recorder.Code != 200 {
+		t.Error("Fail to login with rehashed password", recorder.Body)
+	}
+}
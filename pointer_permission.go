@@ -0,0 +1,164 @@
+package goal
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// PointerPermission grants read and/or write access to the user
+// referenced by a foreign key field, e.g. AuthorID
+type PointerPermission struct {
+	Field string
+	Read  bool
+	Write bool
+}
+
+// PointerPermitter declares pointer permissions of a model. Once a
+// model declares a pointer permission for an action, the action is no
+// longer public: only the referenced user, or roles granted by
+// PermitRead/PermitWrite, can perform it
+type PointerPermitter interface {
+	PointerPermissions() []PointerPermission
+}
+
+// pointerFields returns fields granting the action
+func pointerFields(resource interface{}, read bool) []string {
+	permitter, ok := resource.(PointerPermitter)
+	if !ok {
+		return nil
+	}
+
+	var fields []string
+	for _, permission := range permitter.PointerPermissions() {
+		if (read && permission.Read) || (!read && permission.Write) {
+			fields = append(fields, permission.Field)
+		}
+	}
+
+	return fields
+}
+
+// fieldValue returns the field value as string, dereferencing
+// pointers. Second value is false if the field is nil or missing
+func fieldValue(resource interface{}, name string) (string, bool) {
+	v := reflect.Indirect(reflect.ValueOf(resource))
+	if v.Kind() != reflect.Struct {
+		return "", false
+	}
+
+	field := v.FieldByName(name)
+	if !field.IsValid() {
+		return "", false
+	}
+
+	if field.Kind() == reflect.Ptr {
+		if field.IsNil() {
+			return "", false
+		}
+		field = field.Elem()
+	}
+
+	return fmt.Sprint(field.Interface()), true
+}
+
+// isOwner checks if one of the fields references the user
+func isOwner(resource interface{}, user interface{}, fields []string) bool {
+	if user == nil || !isUserModel(user) {
+		return false
+	}
+
+	id := userID(user)
+	for _, name := range fields {
+		if value, ok := fieldValue(resource, name); ok && value == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// PointerCondition translates read pointer permissions into a SQL
+// condition, e.g. "(author_id = ?)", to filter query results in the
+// database. The last value is false if the model has no read pointer
+// permission
+func PointerCondition(resource interface{}, user interface{}) (string, []interface{}, bool) {
+	fields := pointerFields(resource, true)
+	if len(fields) == 0 {
+		return "", nil, false
+	}
+
+	// Anonymous users own nothing
+	if user == nil || !isUserModel(user) {
+		return "1 = 0", nil, true
+	}
+
+	scope := db.NewScope(resource)
+	id := userID(user)
+
+	var conditions []string
+	var values []interface{}
+	for _, name := range fields {
+		field, ok := scope.FieldByName(name)
+		if !ok {
+			continue
+		}
+
+		conditions = append(conditions, fmt.Sprintf("%s = ?", scope.Quote(field.DBName)))
+		values = append(values, id)
+	}
+
+	if len(conditions) == 0 {
+		return "1 = 0", nil, true
+	}
+
+	return "(" + strings.Join(conditions, " OR ") + ")", values, true
+}
+
+// setPointerFields sets write pointer fields of a new record to
+// current user, so clients can't create records on behalf of others.
+// Without a user, e.g. anonymous or API key requests, the fields are
+// cleared and the record has no owner
+func setPointerFields(resource interface{}, user interface{}) {
+	v := reflect.Indirect(reflect.ValueOf(resource))
+	owned := user != nil && isUserModel(user)
+
+	// Read pointers are left as sent, e.g. to share a record with
+	// another user
+	for _, name := range pointerFields(resource, false) {
+		field := v.FieldByName(name)
+		if !field.IsValid() || !field.CanSet() {
+			continue
+		}
+
+		if !owned {
+			field.Set(reflect.Zero(field.Type()))
+			continue
+		}
+
+		id := reflect.ValueOf(db.NewScope(user).PrimaryKeyValue())
+
+		target := field.Type()
+		if target.Kind() == reflect.Ptr {
+			target = target.Elem()
+		}
+
+		var value reflect.Value
+		switch {
+		case target.Kind() == reflect.String:
+			value = reflect.ValueOf(fmt.Sprint(id.Interface())).Convert(target)
+		case id.Type().ConvertibleTo(target):
+			value = id.Convert(target)
+		default:
+			continue
+		}
+
+		if field.Kind() == reflect.Ptr {
+			ptr := reflect.New(target)
+			ptr.Elem().Set(value)
+			value = ptr
+		}
+
+		field.Set(value)
+	}
+}
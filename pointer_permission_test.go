@@ -0,0 +1,160 @@
+package goal_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/thomasdao/goal"
+)
+
+func (p *post) PointerPermissions() []goal.PointerPermission {
+	return []goal.PointerPermission{{Field: "AuthorID", Read: true, Write: true}}
+}
+
+func (p *post) Get(w http.ResponseWriter, request *http.Request) (int, interface{}, error) {
+	return goal.Read(reflect.TypeOf(p), request)
+}
+
+func (p *post) Post(w http.ResponseWriter, request *http.Request) (int, interface{}, error) {
+	return goal.Create(reflect.TypeOf(p), request)
+}
+
+func (p *post) Put(w http.ResponseWriter, request *http.Request) (int, interface{}, error) {
+	return goal.Update(reflect.TypeOf(p), request)
+}
+
+func (p *post) Query(w http.ResponseWriter, request *http.Request) (int, interface{}, error) {
+	return goal.HandleQuery(reflect.TypeOf(p), request)
+}
+
+// review is shared with a reviewer, who can read but not edit it
+type review struct {
+	ID         uint `gorm:"primary_key"`
+	ReviewerID uint
+	Body       string
+}
+
+func (r *review) PointerPermissions() []goal.PointerPermission {
+	return []goal.PointerPermission{{Field: "ReviewerID", Read: true}}
+}
+
+func (r *review) Post(w http.ResponseWriter, request *http.Request) (int, interface{}, error) {
+	return goal.Create(reflect.TypeOf(r), request)
+}
+
+func TestPointerPermissions(t *testing.T) {
+	setup()
+	defer tearDown()
+
+	send := func(method string, path string, body string, cookie string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		if cookie != "" {
+			req.Header.Add("Cookie", cookie)
+		}
+		goal.SharedAPI().Mux().ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	author := send("POST", "/auth/register", `{"username":"author", "password": "secret-password"}`, "").Header().Get("Set-Cookie")
+	other := send("POST", "/auth/register", `{"username":"other", "password": "secret-password"}`, "").Header().Get("Set-Cookie")
+
+	var user testuser
+	db.Where("username = ?", "author").First(&user)
+
+	// Author is set to current user, whatever client sends
+	res := send("POST", "/post", `{"Title":"Mine", "AuthorID": 999}`, author)
+	if res.Code != 200 {
+		t.Fatal("Fail to create post", res.Body)
+	}
+
+	var created post
+	json.Unmarshal(res.Body.Bytes(), &created)
+	if created.AuthorID != user.ID {
+		t.Fatal("Author should be current user", created.AuthorID)
+	}
+
+	path := fmt.Sprint("/post/", created.ID)
+	cases := []struct {
+		method string
+		cookie string
+		code   int
+	}{
+		{"GET", "", 403},
+		{"GET", other, 403},
+		{"GET", author, 200},
+		{"PUT", other, 403},
+		{"PUT", author, 200},
+	}
+
+	for _, c := range cases {
+		res = send(c.method, path, `{"Title":"Edited"}`, c.cookie)
+		if res.Code != c.code {
+			t.Error("Unexpected status", c.method, c.code, res.Code)
+		}
+	}
+
+	// Query is filtered in database
+	query := "/query/post/" + url.QueryEscape(`{"where":[],"limit":10}`)
+	var results []post
+	json.Unmarshal(send("GET", query, "", other).Body.Bytes(), &results)
+	if len(results) != 0 {
+		t.Error("Other user should not see the post", results)
+	}
+
+	json.Unmarshal(send("GET", query, "", author).Body.Bytes(), &results)
+	if len(results) != 1 {
+		t.Error("Author should see the post", results)
+	}
+
+	// Or conditions of client can't escape the owner filter, which
+	// would fill the page with rows that are filtered out afterwards
+	send("POST", "/post", `{"Title":"Theirs"}`, other)
+	query = "/query/post/" + url.QueryEscape(`{"where":[{"key":"id","op":"=","val":0,"or":[{"key":"id","op":">","val":0}]}],"order":{"id":false},"limit":1}`)
+	results = nil
+	json.Unmarshal(send("GET", query, "", other).Body.Bytes(), &results)
+	if len(results) != 1 || results[0].Title != "Theirs" {
+		t.Error("Or condition should not bypass pointer permission", results)
+	}
+
+	// Anonymous clients can't create records on behalf of a user
+	res = send("POST", "/post", fmt.Sprintf(`{"Title":"Forged", "AuthorID": %d}`, user.ID), "")
+	var forged post
+	db.Where("title = ?", "Forged").First(&forged)
+	if forged.AuthorID != 0 {
+		t.Error("Anonymous client should not set author", res.Code, forged.AuthorID)
+	}
+}
+
+func TestReadPointerNotOverwritten(t *testing.T) {
+	setup()
+	defer tearDown()
+
+	goal.RegisterModel(&review{})
+
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/auth/register", strings.NewReader(`{"username":"writer", "password": "secret-password"}`))
+	goal.SharedAPI().Mux().ServeHTTP(recorder, req)
+	cookie := recorder.Header().Get("Set-Cookie")
+
+	recorder = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/review", strings.NewReader(`{"Body":"Looks good", "ReviewerID": 999}`))
+	req.Header.Add("Cookie", cookie)
+	goal.SharedAPI().Mux().ServeHTTP(recorder, req)
+	if recorder.Code != 200 {
+		t.Fatal("Fail to create review", recorder.Body)
+	}
+
+	// Read-only pointer keeps the user chosen by the client
+	var created review
+	json.Unmarshal(recorder.Body.Bytes(), &created)
+	if created.ReviewerID != 999 {
+		t.Error("Read pointer should not be set to current user", created.ReviewerID)
+	}
+}
@@ -19,7 +19,7 @@ func (api *API) queryHandler(resource interface{}) http.HandlerFunc {
 			handler = resource.Query
 		}
 
-		renderJSON(rw, request, handler)
+		api.renderJSON(rw, request, resource, OperationQuery, handler)
 	}
 }
 
@@ -0,0 +1,159 @@
+package goal
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+var (
+	queryCacheEnabled bool
+	queryCacheTTL     time.Duration
+)
+
+// EnableQueryCache caches rows found by HandleQuery in SharedCache
+// for ttl, zero ttl uses default of the cacher. Cached rows are keyed
+// by the query and ACL keys of current user, and invalidated when a
+// record of any table involved, including included relations, is
+// created, updated or deleted through gorm. Permissions, hooks and
+// field redaction still run on every request
+func EnableQueryCache(ttl time.Duration) {
+	queryCacheEnabled = true
+	queryCacheTTL = ttl
+
+	if db != nil {
+		db.Callback().Create().After("gorm:after_create").Register("goal:query_cache_after_create", bumpQueryGeneration)
+		db.Callback().Update().After("gorm:after_update").Register("goal:query_cache_after_update", bumpQueryGeneration)
+		db.Callback().Delete().After("gorm:after_delete").Register("goal:query_cache_after_delete", bumpQueryGeneration)
+	}
+}
+
+// DisableQueryCache stops caching query results
+func DisableQueryCache() {
+	queryCacheEnabled = false
+}
+
+func queryGenerationKey(table string) string {
+	return stateKey(fmt.Sprintf("goal:query:generation:%s", table))
+}
+
+// queryGeneration is part of cache keys of queries involving the
+// table, changing it invalidates all of them at once
+func queryGeneration(ctx context.Context, table string) int64 {
+	var generation int64
+	stateStore().Get(ctx, queryGenerationKey(table), &generation)
+	return generation
+}
+
+// bumpQueryGeneration invalidates cached queries of the table changed
+// by the scope, and join tables of its many to many relations
+func bumpQueryGeneration(scope *gorm.Scope) {
+	if !queryCacheEnabled || SharedCache == nil || scope.HasError() {
+		return
+	}
+
+	ctx := scopeContext(scope)
+	tables := []string{scope.TableName()}
+	for _, field := range scope.Fields() {
+		if rel := field.Relationship; rel != nil && rel.Kind == "many_to_many" && rel.JoinTableHandler != nil {
+			tables = append(tables, rel.JoinTableHandler.Table(scope.DB()))
+		}
+	}
+
+	now := time.Now().UnixNano()
+	keys := make([]string, 0, len(tables))
+	for _, table := range tables {
+		key := queryGenerationKey(table)
+		// Generations never expire, an evicted one would read as zero
+		// and serve result sets cached before
+		reportCacheError("set", key, setWithExactTTL(ctx, stateStore(), key, now, 0))
+		keys = append(keys, key)
+	}
+
+	publishInvalidation(ctx, keys...)
+}
+
+// queryTables returns tables read by a query, the model table and
+// tables of included relations, e.g. "Author.Profile"
+func queryTables(resource interface{}, includes []string) []string {
+	tables := []string{db.NewScope(resource).TableName()}
+
+	for _, include := range includes {
+		current := resource
+		for _, name := range strings.Split(include, ".") {
+			field, ok := db.NewScope(current).FieldByName(name)
+			if !ok {
+				break
+			}
+
+			if rel := field.Relationship; rel != nil && rel.Kind == "many_to_many" && rel.JoinTableHandler != nil {
+				tables = append(tables, rel.JoinTableHandler.Table(db))
+			}
+
+			t := field.Struct.Type
+			for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+				t = t.Elem()
+			}
+
+			if t.Kind() != reflect.Struct {
+				break
+			}
+
+			current = reflect.New(t).Interface()
+			tables = append(tables, db.NewScope(current).TableName())
+		}
+	}
+
+	return tables
+}
+
+// queryCacheKey returns cache key of query results. Second value is
+// false if the query can't be cached
+func queryCacheKey(resource interface{}, params *QueryParams, request *http.Request) (string, bool) {
+	if !queryCacheEnabled || SharedCache == nil || cacheOptions(resource).Disabled {
+		return "", false
+	}
+
+	// Hooks may change the query in any way
+	if _, ok := resource.(BeforeFindHooker); ok {
+		return "", false
+	}
+
+	ctx := request.Context()
+	tables := queryTables(resource, params.Include)
+	generations := make([]string, 0, len(tables))
+	for _, table := range tables {
+		generations = append(generations, fmt.Sprintf("%s=%d", table, queryGeneration(ctx, table)))
+	}
+
+	// Rows depend on current user through owner permissions
+	var keys []string
+	if IsMasterRequest(request) {
+		keys = []string{"master"}
+	} else {
+		user, _ := GetCurrentUser(request)
+		keys = aclKeys(user)
+		sort.Strings(keys)
+	}
+
+	canonical, err := json.Marshal(struct {
+		Params      *QueryParams `json:"params"`
+		Keys        []string     `json:"keys"`
+		Generations []string     `json:"generations"`
+	}{params, keys, generations})
+	if err != nil {
+		return "", false
+	}
+
+	sum := sha256.Sum256(canonical)
+	return namespacedKey(fmt.Sprintf("goal:query:%s:%s", tables[0], hex.EncodeToString(sum[:]))), true
+}
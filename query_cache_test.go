@@ -0,0 +1,153 @@
+package goal_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/thomasdao/goal"
+)
+
+func (c *comment) Query(w http.ResponseWriter, request *http.Request) (int, interface{}, error) {
+	return goal.HandleQuery(reflect.TypeOf(c), request)
+}
+
+func TestQueryCache(t *testing.T) {
+	setup()
+	defer tearDown()
+
+	previous := goal.SharedCache
+	goal.RegisterCacher(goal.NewMemoryCache(0, 0))
+	goal.EnableQueryCache(0)
+	defer func() {
+		goal.DisableQueryCache()
+		goal.RegisterCacher(previous)
+	}()
+
+	send := func(path string, cookie string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		if cookie != "" {
+			req.Header.Add("Cookie", cookie)
+		}
+		goal.SharedAPI().Mux().ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	parent := &post{Title: "Original"}
+	db.Create(parent)
+	db.Create(&comment{PostID: parent.ID, Text: "First"})
+
+	query := "/query/comment/" + url.QueryEscape(`{"where":[],"include":["Post"]}`)
+	find := func() []comment {
+		var results []comment
+		json.Unmarshal(send(query, "").Body.Bytes(), &results)
+		return results
+	}
+
+	if results := find(); len(results) != 1 || results[0].Post == nil {
+		t.Fatal("Fail to query comments", results)
+	}
+
+	// Rows inserted without gorm callbacks are not seen until
+	// the table changes
+	db.Exec("INSERT INTO comment (post_id, text) VALUES (?, ?)", parent.ID, "Raw")
+	if results := find(); len(results) != 1 {
+		t.Error("Query should be cached", len(results))
+	}
+
+	db.Create(&comment{PostID: parent.ID, Text: "Second"})
+	if results := find(); len(results) != 3 {
+		t.Error("Create should invalidate cached queries", len(results))
+	}
+
+	// Changes of included tables invalidate cached queries too
+	db.Model(parent).Update("title", "Edited")
+	results := find()
+	if len(results) != 3 || results[0].Post == nil || results[0].Post.Title != "Edited" {
+		t.Error("Included table should invalidate cached queries", results)
+	}
+}
+
+func TestQueryCachePerUser(t *testing.T) {
+	setup()
+	defer tearDown()
+
+	previous := goal.SharedCache
+	goal.RegisterCacher(goal.NewMemoryCache(0, 0))
+	goal.EnableQueryCache(0)
+	defer func() {
+		goal.DisableQueryCache()
+		goal.RegisterCacher(previous)
+	}()
+
+	send := func(method string, path string, body string, cookie string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		if cookie != "" {
+			req.Header.Add("Cookie", cookie)
+		}
+		goal.SharedAPI().Mux().ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	author := send("POST", "/auth/register", `{"username":"author", "password": "secret-password"}`, "").Header().Get("Set-Cookie")
+	other := send("POST", "/auth/register", `{"username":"other", "password": "secret-password"}`, "").Header().Get("Set-Cookie")
+	send("POST", "/post", `{"Title":"Mine"}`, author)
+
+	query := "/query/post/" + url.QueryEscape(`{"where":[],"limit":10}`)
+	var results []post
+	json.Unmarshal(send("GET", query, "", author).Body.Bytes(), &results)
+	if len(results) != 1 {
+		t.Fatal("Author should see the post", results)
+	}
+
+	results = nil
+	json.Unmarshal(send("GET", query, "", other).Body.Bytes(), &results)
+	if len(results) != 0 {
+		t.Error("Cached rows of author should not be served to other user", results)
+	}
+}
+
+func TestQueryGenerationNotEvicted(t *testing.T) {
+	setup()
+	defer tearDown()
+
+	previous := goal.SharedCache
+	cache := goal.NewMemoryCache(100, 0)
+	goal.RegisterCacher(cache)
+	goal.EnableQueryCache(0)
+	defer func() {
+		goal.DisableQueryCache()
+		goal.RegisterCacher(previous)
+	}()
+
+	query := "/query/comment/" + url.QueryEscape(`{"where":[]}`)
+	find := func() []comment {
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", query, nil)
+		goal.SharedAPI().Mux().ServeHTTP(recorder, req)
+
+		var results []comment
+		json.Unmarshal(recorder.Body.Bytes(), &results)
+		return results
+	}
+
+	find()
+	db.Create(&comment{Text: "First"})
+	if results := find(); len(results) != 1 {
+		t.Fatal("Create should invalidate cached queries", len(results))
+	}
+
+	// Bounded cache may evict any of its entries, the generation of
+	// the table must not be one of them
+	cache.Delete(context.Background(), "goal:query:generation:comment")
+	if results := find(); len(results) != 1 {
+		t.Error("Stale query results should not be served", len(results))
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"net/http"
 	"net/url"
 	"reflect"
+	"strings"
 
 	"github.com/gorilla/mux"
 	"github.com/jinzhu/gorm"
@@ -82,21 +83,38 @@ type QueryParams struct {
 // Find constructs the query, return error immediately if query is invalid,
 // and query database if everything is valid
 func (params *QueryParams) Find(resource interface{}, results interface{}) error {
+	qryDB, err := params.query(resource)
+	if err != nil {
+		return err
+	}
+
+	// Query the database
+	qryDB.Find(results)
+
+	return nil
+}
+
+// query constructs the query, return error immediately if query is invalid
+func (params *QueryParams) query(resource interface{}) (*gorm.DB, error) {
 	scope := db.NewScope(resource)
 
 	qryDB := db.New()
 
-	// Parse where clause
+	// Parse where clause into one group, so conditions added later
+	// by pointer permissions or hooks can't be bypassed with "or"
+	var ands, ors []string
+	var andVals, orVals []interface{}
 	if params.Where != nil {
 		for _, item := range params.Where {
 			query, err := item.getQuery(scope)
 
 			// Return immediately if query is invalid
 			if err != nil {
-				return err
+				return nil, err
 			}
 
-			qryDB = qryDB.Where(query, item.Val)
+			ands = append(ands, "("+query+")")
+			andVals = append(andVals, item.Val)
 
 			if item.Or != nil {
 				for _, orItem := range item.Or {
@@ -104,15 +122,24 @@ func (params *QueryParams) Find(resource interface{}, results interface{}) error
 
 					// Return immediately if query is invalid
 					if err != nil {
-						return err
+						return nil, err
 					}
 
-					qryDB = qryDB.Or(query, orItem.Val)
+					ors = append(ors, "("+query+")")
+					orVals = append(orVals, orItem.Val)
 				}
 			}
 		}
 	}
 
+	if len(ands) > 0 {
+		condition := strings.Join(ands, " AND ")
+		if len(ors) > 0 {
+			condition += " OR " + strings.Join(ors, " OR ")
+		}
+		qryDB = qryDB.Where(condition, append(andVals, orVals...)...)
+	}
+
 	if params.Limit != 0 {
 		qryDB = qryDB.Limit(params.Limit)
 	}
@@ -121,7 +148,7 @@ func (params *QueryParams) Find(resource interface{}, results interface{}) error
 		for name, order := range params.Order {
 			if !scope.HasColumn(name) {
 				errorMsg := fmt.Sprintf("Column %s does not exist", name)
-				return errors.New(errorMsg)
+				return nil, errors.New(errorMsg)
 			}
 
 			qryDB = qryDB.Order(name, order)
@@ -134,10 +161,7 @@ func (params *QueryParams) Find(resource interface{}, results interface{}) error
 		}
 	}
 
-	// Query the database
-	qryDB.Find(results)
-
-	return nil
+	return qryDB, nil
 }
 
 // HandleQuery retrieves results filtered by request parameters
@@ -165,11 +189,47 @@ func HandleQuery(rType reflect.Type, request *http.Request) (int, interface{}, e
 	resource := newObjectWithType(rType)
 	results := dynamicSlice(resource)
 
-	err = params.Find(resource, results)
+	qryDB, err := params.query(resource)
 	if err != nil {
 		return 500, nil, err
 	}
 
+	// Filter by owner in database when pointer permissions are
+	// the only read rule of the model
+	_, hasACL := resource.(ACLer)
+	_, hasRoles := resource.(PermitReader)
+	if !hasACL && !hasRoles && !IsMasterRequest(request) {
+		user, _ := GetCurrentUser(request)
+		if condition, values, ok := PointerCondition(resource, user); ok {
+			qryDB = qryDB.Where(condition, values...)
+		}
+	}
+
+	// Hook may add conditions to the query
+	if hooker, ok := resource.(BeforeFindHooker); ok {
+		trigger := newTrigger(request, OperationQuery, nil, nil)
+		trigger.Query = qryDB
+		err = hooker.HookBeforeFind(trigger)
+		if err != nil {
+			return hookResponse(err)
+		}
+		qryDB = trigger.Query
+	}
+
+	// Query the database, unless the rows are cached
+	ctx := request.Context()
+	cacheKey, cacheable := queryCacheKey(resource, &params, request)
+	if !cacheable || SharedCache.Get(ctx, cacheKey, results) != nil {
+		err = findRows(ctx, resource, qryDB, params.Include, results)
+		if err != nil {
+			return 500, nil, err
+		}
+
+		if cacheable {
+			reportCacheError("set", cacheKey, setWithTTL(ctx, SharedCache, cacheKey, results, queryCacheTTL))
+		}
+	}
+
 	// Check permission for each item, remove item which doesn't have permission
 	var filtered []interface{}
 
@@ -190,5 +250,15 @@ func HandleQuery(rType reflect.Type, request *http.Request) (int, interface{}, e
 		panic("results should be a slice")
 	}
 
+	if hooker, ok := resource.(AfterFindHooker); ok {
+		for _, item := range filtered {
+			err = hooker.HookAfterFind(newTrigger(request, OperationQuery, item, nil))
+			if err != nil {
+				return hookResponse(err)
+			}
+		}
+	}
+
+	redactForRequest(filtered, request)
 	return 200, filtered, nil
 }
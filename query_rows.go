@@ -0,0 +1,214 @@
+package goal
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// findRows runs the query into results. With SharedCache, only ids are
+// selected from database, rows are read from cache in one batch and
+// rows missing from cache are loaded with a single query
+func findRows(ctx context.Context, resource interface{}, qryDB *gorm.DB, includes []string, results interface{}) error {
+	qryDB = qryDB.Set(contextSettingKey, ctx)
+
+	relations, ok := cachedRelations(resource, includes)
+	if SharedCache == nil || !ok {
+		return qryDB.Find(results).Error
+	}
+
+	scope := db.NewScope(resource)
+	column := fmt.Sprintf("%v.%v", scope.QuotedTableName(), scope.Quote(scope.PrimaryKey()))
+
+	var ids []interface{}
+	err := qryDB.Model(resource).Pluck(column, &ids).Error
+	if err != nil {
+		return err
+	}
+
+	rows, err := findCachedRecords(ctx, reflect.TypeOf(resource), ids)
+	if err != nil {
+		return err
+	}
+
+	slice := reflect.ValueOf(results).Elem()
+	for _, row := range rows {
+		if row != nil {
+			slice.Set(reflect.Append(slice, reflect.ValueOf(row)))
+		}
+	}
+
+	for _, name := range relations {
+		err = loadBelongsTo(ctx, slice, name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// cachedRelations checks that every included relation is a belongs to
+// relation referencing the primary key of the related model, which can
+// be loaded from cache by id
+func cachedRelations(resource interface{}, includes []string) ([]string, bool) {
+	scope := db.NewScope(resource)
+	if scope.PrimaryField() == nil || cacheOptions(resource).Disabled {
+		return nil, false
+	}
+
+	for _, name := range includes {
+		field, ok := scope.FieldByName(name)
+		if !ok || field.Relationship == nil || field.Relationship.Kind != "belongs_to" ||
+			len(field.Relationship.ForeignFieldNames) != 1 {
+			return nil, false
+		}
+
+		related := db.NewScope(newRecord(field.Struct.Type))
+		if related.PrimaryField() == nil || cacheOptions(related.Value).Disabled ||
+			related.PrimaryField().DBName != field.Relationship.AssociationForeignDBNames[0] {
+			return nil, false
+		}
+	}
+
+	return includes, true
+}
+
+// findCachedRecords returns records of the type with the ids, in the
+// same order, nil for records which don't exist
+func findCachedRecords(ctx context.Context, rType reflect.Type, ids []interface{}) ([]interface{}, error) {
+	records := make([]interface{}, len(ids))
+	if len(ids) == 0 {
+		return records, nil
+	}
+
+	name := TableName(newRecord(rType))
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		if data, ok := id.([]byte); ok {
+			id = string(data)
+		}
+
+		keys[i] = DefaultCacheKey(name, id)
+		records[i] = newRecord(rType)
+	}
+
+	found, err := getMulti(ctx, SharedCache, keys, records)
+	if err != nil {
+		reportCacheError("get", strings.Join(keys, " "), err)
+		found = make([]bool, len(keys))
+	}
+
+	var missing []interface{}
+	for i, hit := range found {
+		if !hit {
+			missing = append(missing, ids[i])
+		}
+	}
+
+	if len(missing) == 0 {
+		return records, nil
+	}
+
+	// Loaded rows are cached by the query callback
+	resource := newRecord(rType)
+	scope := db.NewScope(resource)
+	loaded := dynamicSlice(resource)
+	err = contextDB(ctx).Where(fmt.Sprintf("%v.%v IN (?)", scope.QuotedTableName(), scope.Quote(scope.PrimaryKey())), missing).
+		Find(loaded).Error
+	if err != nil {
+		return nil, err
+	}
+
+	byKey := make(map[string]interface{})
+	rows := reflect.ValueOf(loaded).Elem()
+	for i := 0; i < rows.Len(); i++ {
+		row := rows.Index(i).Interface()
+		byKey[cacheKeyFromScope(db.NewScope(row))] = row
+	}
+
+	for i, hit := range found {
+		if !hit {
+			records[i] = byKey[keys[i]]
+		}
+	}
+
+	return records, nil
+}
+
+// loadBelongsTo sets the relation of every row, related records are
+// read from cache in one batch
+func loadBelongsTo(ctx context.Context, rows reflect.Value, name string) error {
+	if rows.Len() == 0 {
+		return nil
+	}
+
+	field, _ := db.NewScope(rows.Index(0).Interface()).FieldByName(name)
+	foreignKey := field.Relationship.ForeignFieldNames[0]
+
+	// Unique foreign keys, rows without one have no relation
+	var ids []interface{}
+	indexes := make(map[string]int)
+	for i := 0; i < rows.Len(); i++ {
+		fk, ok := db.NewScope(rows.Index(i).Interface()).FieldByName(foreignKey)
+		if !ok || fk.IsBlank {
+			continue
+		}
+
+		id := foreignKeyValue(fk)
+		key := fmt.Sprint(id)
+		if _, exists := indexes[key]; !exists {
+			indexes[key] = len(ids)
+			ids = append(ids, id)
+		}
+	}
+
+	related, err := findCachedRecords(ctx, field.Struct.Type, ids)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < rows.Len(); i++ {
+		row := reflect.Indirect(rows.Index(i))
+		fk, _ := db.NewScope(rows.Index(i).Interface()).FieldByName(foreignKey)
+		value := row.FieldByName(name)
+
+		if fk.IsBlank {
+			value.Set(reflect.Zero(value.Type()))
+			continue
+		}
+
+		index, ok := indexes[fmt.Sprint(foreignKeyValue(fk))]
+		if !ok || related[index] == nil {
+			value.Set(reflect.Zero(value.Type()))
+			continue
+		}
+
+		record := reflect.ValueOf(related[index])
+		if value.Kind() != reflect.Ptr {
+			record = record.Elem()
+		}
+		value.Set(record)
+	}
+
+	return nil
+}
+
+// foreignKeyValue returns value of a foreign key, dereferencing
+// nullable keys like *uint
+func foreignKeyValue(fk *gorm.Field) interface{} {
+	return reflect.Indirect(fk.Field).Interface()
+}
+
+// newRecord returns a pointer to a new record of a model or pointer
+// to model type
+func newRecord(t reflect.Type) interface{} {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	return reflect.New(t).Interface()
+}
@@ -0,0 +1,198 @@
+package goal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// DefaultInvalidationChannel is the Redis channel used by RedisBus
+const DefaultInvalidationChannel = "goal:invalidation"
+
+// RedisBus implements InvalidationBus with Redis pub/sub. Messages
+// published while a subscriber is reconnecting are lost, so the
+// subscriber is sent an Invalidation with All set once reconnected
+type RedisBus struct {
+	// Pool defaults to the pool of RedisCache
+	Pool *redis.Pool
+
+	// Channel defaults to DefaultInvalidationChannel in the cache
+	// namespace
+	Channel string
+}
+
+func (bus *RedisBus) pool() *redis.Pool {
+	if bus.Pool != nil {
+		return bus.Pool
+	}
+
+	return pool
+}
+
+func (bus *RedisBus) channel() string {
+	if bus.Channel != "" {
+		return bus.Channel
+	}
+
+	return namespacedKey(DefaultInvalidationChannel)
+}
+
+// Publish conforms to InvalidationBus interface
+func (bus *RedisBus) Publish(ctx context.Context, invalidation *Invalidation) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	p := bus.pool()
+	if p == nil {
+		return errors.New("redis pool is not initialized")
+	}
+
+	data, err := json.Marshal(invalidation)
+	if err != nil {
+		return err
+	}
+
+	conn := p.Get()
+	defer conn.Close()
+
+	_, err = conn.Do("PUBLISH", bus.channel(), data)
+	return err
+}
+
+// Subscribe conforms to InvalidationBus interface. The subscription
+// uses its own connection and reconnects when it is lost
+func (bus *RedisBus) Subscribe(handler func(*Invalidation)) (func(), error) {
+	conn, err := bus.subscribe()
+	if err != nil {
+		return nil, err
+	}
+
+	sub := &redisSubscription{conn: conn}
+	go bus.receive(sub, handler)
+
+	return sub.close, nil
+}
+
+func (bus *RedisBus) subscribe() (redis.PubSubConn, error) {
+	p := bus.pool()
+	if p == nil {
+		return redis.PubSubConn{}, errors.New("redis pool is not initialized")
+	}
+
+	c, err := p.Dial()
+	if err != nil {
+		return redis.PubSubConn{}, err
+	}
+
+	conn := redis.PubSubConn{Conn: c}
+	err = conn.Subscribe(bus.channel())
+	if err != nil {
+		conn.Close()
+		return redis.PubSubConn{}, err
+	}
+
+	return conn, nil
+}
+
+// Delay before reconnecting a lost subscription, doubled on every
+// failed attempt
+const (
+	minBusRetryDelay = time.Second
+	maxBusRetryDelay = 30 * time.Second
+)
+
+func (bus *RedisBus) receive(sub *redisSubscription, handler func(*Invalidation)) {
+	delay := minBusRetryDelay
+	subscribed := false
+
+	conn, ok := sub.current()
+	for ok {
+		switch msg := conn.Receive().(type) {
+		case redis.Message:
+			var invalidation Invalidation
+			err := json.Unmarshal(msg.Data, &invalidation)
+			if err != nil {
+				reportCacheError("decode", msg.Channel, err)
+				continue
+			}
+
+			handler(&invalidation)
+		case redis.Subscription:
+			// Changes published while reconnecting are lost
+			if subscribed {
+				handler(&Invalidation{All: true})
+			}
+
+			subscribed = true
+			delay = minBusRetryDelay
+		case error:
+			if _, ok = sub.current(); !ok {
+				return
+			}
+
+			reportCacheError("subscribe", bus.channel(), msg)
+			time.Sleep(delay)
+			if delay *= 2; delay > maxBusRetryDelay {
+				delay = maxBusRetryDelay
+			}
+
+			var err error
+			conn, ok, err = sub.reconnect(bus)
+			if ok && err != nil {
+				reportCacheError("subscribe", bus.channel(), err)
+			}
+		}
+	}
+}
+
+// redisSubscription holds the connection of a subscriber
+type redisSubscription struct {
+	mutex   sync.Mutex
+	conn    redis.PubSubConn
+	stopped bool
+}
+
+func (sub *redisSubscription) current() (redis.PubSubConn, bool) {
+	sub.mutex.Lock()
+	defer sub.mutex.Unlock()
+	return sub.conn, !sub.stopped
+}
+
+// reconnect replaces a lost connection, the old one is kept if
+// subscribing fails so the next receive retries
+func (sub *redisSubscription) reconnect(bus *RedisBus) (redis.PubSubConn, bool, error) {
+	conn, err := bus.subscribe()
+
+	sub.mutex.Lock()
+	defer sub.mutex.Unlock()
+
+	if sub.stopped {
+		if err == nil {
+			conn.Close()
+		}
+		return sub.conn, false, nil
+	}
+
+	if err != nil {
+		return sub.conn, true, err
+	}
+
+	sub.conn.Close()
+	sub.conn = conn
+	return conn, true, nil
+}
+
+func (sub *redisSubscription) close() {
+	sub.mutex.Lock()
+	defer sub.mutex.Unlock()
+
+	if !sub.stopped {
+		sub.stopped = true
+		sub.conn.Close()
+	}
+}
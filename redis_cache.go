@@ -4,18 +4,41 @@
 package goal
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/garyburd/redigo/redis"
 )
 
-// RedisCache implements Cacher interface
-type RedisCache struct{}
+// RedisCache implements Cacher and ExpiringCacher interfaces. Values
+// are encoded by CacheEncoding, JSON by default
+type RedisCache struct {
+	// TTL is the default expiry of keys, zero means never
+	TTL time.Duration
+
+	CacheEncoding
+}
+
+// redisSeconds rounds ttl up to whole seconds used by EX and EXPIRE
+func redisSeconds(ttl time.Duration) int64 {
+	seconds := int64((ttl + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	return seconds
+}
 
 // Get returns data for a key
-func (cache *RedisCache) Get(key string, val interface{}) error {
-	conn, err := pool.Dial()
+func (cache *RedisCache) Get(ctx context.Context, key string, val interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	conn, err := redisConn()
 	if err != nil {
 		fmt.Println(err)
 		return err
@@ -29,19 +52,39 @@ func (cache *RedisCache) Get(key string, val interface{}) error {
 
 	var reply []byte
 	reply, err = redis.Bytes(conn.Do("GET", key))
+	if err == redis.ErrNil {
+		return ErrCacheMiss
+	}
+
 	if err != nil {
 		return err
 	}
 
-	// Populate resource
-	json.Unmarshal(reply, val)
+	// Populate resource. Entries which can't be decoded, e.g. written
+	// for an older schema version, are misses
+	err = cache.Unmarshal(reply, val)
+	if err != nil {
+		if err != ErrSchemaMismatch {
+			reportCacheError("decode", key, err)
+		}
+		return ErrCacheMiss
+	}
 
 	return nil
 }
 
-// Set a val for a key into Redis
-func (cache *RedisCache) Set(key string, val interface{}) error {
-	conn, err := pool.Dial()
+// Set a val for a key into Redis, with default TTL
+func (cache *RedisCache) Set(ctx context.Context, key string, val interface{}) error {
+	return cache.SetWithTTL(ctx, key, val, cache.TTL)
+}
+
+// SetWithTTL sets a val for a key into Redis, expiring after ttl
+func (cache *RedisCache) SetWithTTL(ctx context.Context, key string, val interface{}, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	conn, err := redisConn()
 	if err != nil {
 		fmt.Println(err)
 		return err
@@ -50,18 +93,60 @@ func (cache *RedisCache) Set(key string, val interface{}) error {
 	defer conn.Close()
 
 	var data []byte
-	data, err = json.Marshal(val)
+	data, err = cache.Marshal(val)
 	if err != nil {
 		return err
 	}
 
-	_, err = conn.Do("SET", key, data)
+	if ttl > 0 {
+		_, err = conn.Do("SET", key, data, "EX", redisSeconds(ttl))
+	} else {
+		_, err = conn.Do("SET", key, data)
+	}
+
+	return err
+}
+
+// Touch resets expiry of a key, returns ErrCacheMiss if the key
+// doesn't exist
+func (cache *RedisCache) Touch(ctx context.Context, key string, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	conn, err := redisConn()
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	defer conn.Close()
+
+	var updated bool
+	if ttl > 0 {
+		updated, err = redis.Bool(conn.Do("EXPIRE", key, redisSeconds(ttl)))
+	} else {
+		updated, err = redis.Bool(conn.Do("PERSIST", key))
+		if err == nil && !updated {
+			// PERSIST also returns 0 for keys without expiry
+			updated, err = redis.Bool(conn.Do("EXISTS", key))
+		}
+	}
+
+	if err == nil && !updated {
+		return ErrCacheMiss
+	}
+
 	return err
 }
 
 // Delete a key from Redis
-func (cache *RedisCache) Delete(key string) error {
-	conn, err := pool.Dial()
+func (cache *RedisCache) Delete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	conn, err := redisConn()
 	if err != nil {
 		fmt.Println(err)
 		return err
@@ -74,8 +159,12 @@ func (cache *RedisCache) Delete(key string) error {
 }
 
 // Exists checks if a key exists inside Redis
-func (cache *RedisCache) Exists(key string) (bool, error) {
-	conn, err := pool.Dial()
+func (cache *RedisCache) Exists(ctx context.Context, key string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	conn, err := redisConn()
 	if err != nil {
 		fmt.Println(err)
 		return false, err
@@ -89,8 +178,157 @@ func (cache *RedisCache) Exists(key string) (bool, error) {
 	return reply, err
 }
 
+// GetMulti conforms to BatchCacher interface, keys are read with one
+// MGET
+func (cache *RedisCache) GetMulti(ctx context.Context, keys []string, vals []interface{}) ([]bool, error) {
+	found := make([]bool, len(keys))
+	if err := ctx.Err(); err != nil {
+		return found, err
+	}
+
+	if len(keys) == 0 {
+		return found, nil
+	}
+
+	conn, err := redisConn()
+	if err != nil {
+		fmt.Println(err)
+		return found, err
+	}
+
+	defer conn.Close()
+
+	args := make([]interface{}, len(keys))
+	for i, key := range keys {
+		args[i] = key
+	}
+
+	replies, err := redis.Values(conn.Do("MGET", args...))
+	if err != nil {
+		return found, err
+	}
+
+	for i, reply := range replies {
+		data, ok := reply.([]byte)
+		if !ok {
+			continue
+		}
+
+		err = cache.Unmarshal(data, vals[i])
+		if err != nil {
+			if err != ErrSchemaMismatch {
+				reportCacheError("decode", keys[i], err)
+			}
+			continue
+		}
+		found[i] = true
+	}
+
+	return found, nil
+}
+
+// SetMulti conforms to BatchCacher interface, keys are written in one
+// pipeline
+func (cache *RedisCache) SetMulti(ctx context.Context, keys []string, vals []interface{}, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if len(keys) == 0 {
+		return nil
+	}
+
+	if ttl <= 0 {
+		ttl = cache.TTL
+	}
+
+	conn, err := redisConn()
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	defer conn.Close()
+
+	for i, key := range keys {
+		var data []byte
+		data, err = cache.Marshal(vals[i])
+		if err != nil {
+			return err
+		}
+
+		if ttl > 0 {
+			err = conn.Send("SET", key, data, "EX", redisSeconds(ttl))
+		} else {
+			err = conn.Send("SET", key, data)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	err = conn.Flush()
+	if err != nil {
+		return err
+	}
+
+	// Read every reply, the first error is returned
+	var firstErr error
+	for range keys {
+		_, err = conn.Receive()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// DeleteMulti conforms to BatchCacher interface
+func (cache *RedisCache) DeleteMulti(ctx context.Context, keys []string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if len(keys) == 0 {
+		return nil
+	}
+
+	conn, err := redisConn()
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	defer conn.Close()
+
+	args := make([]interface{}, len(keys))
+	for i, key := range keys {
+		args[i] = key
+	}
+
+	_, err = conn.Do("DEL", args...)
+	return err
+}
+
 var pool *redis.Pool
 
+// redisConn gets a connection from the pool, Close returns it
+func redisConn() (redis.Conn, error) {
+	if pool == nil {
+		return nil, errors.New("redis pool is not initialized")
+	}
+
+	conn := pool.Get()
+	if err := conn.Err(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
 // InitRedisPool initializes Redis and connection pool
 func (cache *RedisCache) InitRedisPool(p *redis.Pool) error {
 	pool = p
@@ -110,12 +348,81 @@ func Pool() *redis.Pool {
 	return pool
 }
 
-// RedisClearAll clear all data from connection's CURRENT database
+// ClearPrefix conforms to PrefixClearer interface. Keys are found
+// with SCAN, so other keys of the database are kept
+func (cache *RedisCache) ClearPrefix(ctx context.Context, prefix string) error {
+	conn, err := redisConn()
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	defer conn.Close()
+
+	return redisDeletePrefix(ctx, conn, prefix, true)
+}
+
+// redisDeletePrefix deletes keys matching the prefix in batches,
+// keys of internal state are kept if keepState is true
+func redisDeletePrefix(ctx context.Context, conn redis.Conn, prefix string, keepState bool) error {
+	pattern := redisGlobEscaper.Replace(prefix) + "*"
+	cursor := "0"
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		reply, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", pattern, "COUNT", 1000))
+		if err != nil {
+			return err
+		}
+
+		if len(reply) != 2 {
+			return errors.New("unexpected reply of SCAN")
+		}
+
+		cursor, err = redis.String(reply[0], nil)
+		if err != nil {
+			return err
+		}
+
+		keys, err := redis.Strings(reply[1], nil)
+		if err != nil {
+			return err
+		}
+
+		var deleted []interface{}
+		for _, key := range keys {
+			if !keepState || !isStateKey(key) {
+				deleted = append(deleted, key)
+			}
+		}
+
+		if len(deleted) > 0 {
+			_, err = conn.Do("DEL", deleted...)
+			if err != nil {
+				return err
+			}
+		}
+
+		if cursor == "0" {
+			return nil
+		}
+	}
+}
+
+// redisGlobEscaper escapes special characters of MATCH patterns
+var redisGlobEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+// RedisClearAll clears keys of the cache namespace from the connection's
+// CURRENT database, or every key of it if no namespace is set. Unlike
+// ClearCache, it also drops session invalidation and failed login
+// attempts stored under the cache namespace, so use it for tests only
 func RedisClearAll() error {
 	if pool == nil {
 		return nil
 	}
-	conn, err := pool.Dial()
+	conn, err := redisConn()
 	if err != nil {
 		fmt.Println(err)
 		return err
@@ -123,8 +430,7 @@ func RedisClearAll() error {
 
 	defer conn.Close()
 
-	_, err = conn.Do("FLUSHDB")
-
+	err = redisDeletePrefix(context.Background(), conn, cacheNamespace, false)
 	if err != nil {
 		fmt.Println("Error clear redis ", err)
 	}
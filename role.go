@@ -0,0 +1,432 @@
+package goal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Role is a named group of users, stored in database so roles can be
+// granted without code change. A role can inherit other roles, e.g.
+// "admin" inherits "editor", so admins can do everything editors can
+type Role struct {
+	ID        uint   `gorm:"primary_key" json:"id"`
+	Name      string `gorm:"unique_index" json:"name"`
+	CreatedAt time.Time
+}
+
+// TableName conforms to gorm naming interface
+func (role *Role) TableName() string {
+	return "roles"
+}
+
+// RoleMembership assigns a user to a role
+type RoleMembership struct {
+	ID     uint   `gorm:"primary_key" json:"id"`
+	RoleID uint   `gorm:"unique_index:idx_role_user" json:"role_id"`
+	UserID string `gorm:"unique_index:idx_role_user" json:"user_id"`
+}
+
+// TableName conforms to gorm naming interface
+func (membership *RoleMembership) TableName() string {
+	return "role_users"
+}
+
+// RoleInheritance gives members of RoleID all roles of InheritedRoleID
+type RoleInheritance struct {
+	ID              uint `gorm:"primary_key" json:"id"`
+	RoleID          uint `gorm:"unique_index:idx_role_inherit" json:"role_id"`
+	InheritedRoleID uint `gorm:"unique_index:idx_role_inherit" json:"inherited_role_id"`
+}
+
+// TableName conforms to gorm naming interface
+func (inheritance *RoleInheritance) TableName() string {
+	return "role_inherits"
+}
+
+var rolesEnabled bool
+
+// EnableRoles creates role tables and adds stored roles of current user
+// to the roles checked against PermitRead and PermitWrite
+func EnableRoles() {
+	db.AutoMigrate(&Role{}, &RoleMembership{}, &RoleInheritance{})
+	rolesEnabled = true
+}
+
+func roleGenerationKey() string {
+	return stateKey("goal:roles:generation")
+}
+
+// roleCacheTTL expires resolved roles of older generations
+const roleCacheTTL = time.Hour
+
+// roleGeneration is part of cache keys of resolved roles, changing
+// it invalidates all of them at once
+func roleGeneration(ctx context.Context) int64 {
+	var generation int64
+	stateStore().Get(ctx, roleGenerationKey(), &generation)
+	return generation
+}
+
+// invalidateRoles starts a new generation, which never expires, so
+// roles resolved before can't come back
+func invalidateRoles() {
+	setWithExactTTL(context.Background(), stateStore(), roleGenerationKey(), time.Now().UnixNano(), 0)
+}
+
+// isUserModel checks if value is the registered user model, so
+// api keys are not mistaken for users with the same id
+func isUserModel(user interface{}) bool {
+	return userType != nil && reflect.TypeOf(user) == reflect.PtrTo(userType)
+}
+
+// StoredRoles returns names of all roles of the user, including
+// inherited ones. Results are cached until roles are changed
+func StoredRoles(ctx context.Context, user interface{}) ([]string, error) {
+	if !rolesEnabled || !isUserModel(user) {
+		return nil, nil
+	}
+
+	id := userID(user)
+	key := namespacedKey(fmt.Sprintf("goal:roles:%v:%s", roleGeneration(ctx), id))
+
+	var names []string
+	err := stateStore().Get(ctx, key, &names)
+	if err == nil {
+		return names, nil
+	}
+
+	ctxDB := contextDB(ctx)
+	var roleIDs []uint
+	err = ctxDB.Model(&RoleMembership{}).Where("user_id = ?", id).Pluck("role_id", &roleIDs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// Walk inheritance graph, visited set protects against cycles
+	visited := make(map[uint]bool)
+	for len(roleIDs) > 0 {
+		var next []uint
+		for _, roleID := range roleIDs {
+			if !visited[roleID] {
+				visited[roleID] = true
+				next = append(next, roleID)
+			}
+		}
+
+		if len(next) == 0 {
+			break
+		}
+
+		roleIDs = nil
+		err = ctxDB.Model(&RoleInheritance{}).Where("role_id in (?)", next).Pluck("inherited_role_id", &roleIDs).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	names = []string{}
+	if len(visited) > 0 {
+		ids := make([]uint, 0, len(visited))
+		for roleID := range visited {
+			ids = append(ids, roleID)
+		}
+
+		err = ctxDB.Model(&Role{}).Where("id in (?)", ids).Order("name").Pluck("name", &names).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	setWithTTL(ctx, stateStore(), key, names, roleCacheTTL)
+	return names, nil
+}
+
+// UserRoles returns roles of the user from Roler interface and, if
+// enabled, roles stored in database
+func UserRoles(user interface{}) []string {
+	return contextUserRoles(context.Background(), user)
+}
+
+// contextUserRoles is UserRoles loading stored roles with the context
+func contextUserRoles(ctx context.Context, user interface{}) []string {
+	var roles []string
+	if roler, ok := user.(Roler); ok {
+		roles = append(roles, roler.Roles()...)
+	}
+
+	stored, err := StoredRoles(ctx, user)
+	if err != nil {
+		fmt.Println("Unable to load roles", err)
+	}
+
+	return append(roles, stored...)
+}
+
+// CreateRole saves a new role
+func CreateRole(name string) (*Role, error) {
+	if name == "" {
+		return nil, errors.New("role name is required")
+	}
+
+	role := &Role{Name: name}
+	err := db.Create(role).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return role, nil
+}
+
+// DeleteRole removes a role with its memberships and inheritances
+func DeleteRole(roleID uint) error {
+	tx := db.Begin()
+	err := tx.Where("role_id = ?", roleID).Delete(&RoleMembership{}).Error
+	if err == nil {
+		err = tx.Where("role_id = ? OR inherited_role_id = ?", roleID, roleID).Delete(&RoleInheritance{}).Error
+	}
+
+	if err == nil {
+		err = tx.Where("id = ?", roleID).Delete(&Role{}).Error
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit().Error
+	invalidateRoles()
+	return err
+}
+
+// AddUserToRole makes the user a member of the role
+func AddUserToRole(roleID uint, userID string) error {
+	err := db.Create(&RoleMembership{RoleID: roleID, UserID: userID}).Error
+	invalidateRoles()
+	return err
+}
+
+// RemoveUserFromRole removes the user from members of the role
+func RemoveUserFromRole(roleID uint, userID string) error {
+	err := db.Where("role_id = ? AND user_id = ?", roleID, userID).Delete(&RoleMembership{}).Error
+	invalidateRoles()
+	return err
+}
+
+// AddRoleInheritance gives members of the role all roles of inheritedRoleID
+func AddRoleInheritance(roleID uint, inheritedRoleID uint) error {
+	if roleID == inheritedRoleID {
+		return errors.New("role can't inherit itself")
+	}
+
+	err := db.Create(&RoleInheritance{RoleID: roleID, InheritedRoleID: inheritedRoleID}).Error
+	invalidateRoles()
+	return err
+}
+
+// RemoveRoleInheritance stops the role inheriting inheritedRoleID
+func RemoveRoleInheritance(roleID uint, inheritedRoleID uint) error {
+	err := db.Where("role_id = ? AND inherited_role_id = ?", roleID, inheritedRoleID).Delete(&RoleInheritance{}).Error
+	invalidateRoles()
+	return err
+}
+
+// roleFromRequest loads the role with id from url
+func roleFromRequest(request *http.Request) (*Role, error) {
+	role := &Role{}
+	err := db.First(role, mux.Vars(request)["id"]).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return role, nil
+}
+
+// HandleRoles lists (GET) and creates (POST) roles
+func HandleRoles(w http.ResponseWriter, request *http.Request) (int, interface{}, error) {
+	code, err := requireAdmin(request)
+	if err != nil {
+		return code, nil, err
+	}
+
+	switch request.Method {
+	case GET:
+		var roles []*Role
+		err = db.Order("name").Find(&roles).Error
+		if err != nil {
+			return 500, nil, err
+		}
+
+		return 200, roles, nil
+	case POST:
+		var params struct {
+			Name string `json:"name"`
+		}
+
+		err = json.NewDecoder(request.Body).Decode(&params)
+		if err != nil {
+			return 400, nil, err
+		}
+
+		role, err := CreateRole(params.Name)
+		if err != nil {
+			return 500, nil, err
+		}
+
+		return 200, role, nil
+	}
+
+	return 405, nil, http.ErrNotSupported
+}
+
+// HandleRole returns (GET) a role with its members and inherited
+// roles, or deletes (DELETE) it
+func HandleRole(w http.ResponseWriter, request *http.Request) (int, interface{}, error) {
+	code, err := requireAdmin(request)
+	if err != nil {
+		return code, nil, err
+	}
+
+	role, err := roleFromRequest(request)
+	if err != nil {
+		return 404, nil, err
+	}
+
+	switch request.Method {
+	case GET:
+		var users []string
+		var inherits []uint
+		err = db.Model(&RoleMembership{}).Where("role_id = ?", role.ID).Pluck("user_id", &users).Error
+		if err == nil {
+			err = db.Model(&RoleInheritance{}).Where("role_id = ?", role.ID).Pluck("inherited_role_id", &inherits).Error
+		}
+
+		if err != nil {
+			return 500, nil, err
+		}
+
+		result := map[string]interface{}{
+			"role":     role,
+			"users":    users,
+			"inherits": inherits,
+		}
+
+		return 200, result, nil
+	case DELETE:
+		err = DeleteRole(role.ID)
+		if err != nil {
+			return 500, nil, err
+		}
+
+		return 200, nil, nil
+	}
+
+	return 405, nil, http.ErrNotSupported
+}
+
+// HandleRoleUsers adds (POST {"user_id": "1"}) or removes
+// (DELETE /{user_id}) members of a role
+func HandleRoleUsers(w http.ResponseWriter, request *http.Request) (int, interface{}, error) {
+	code, err := requireAdmin(request)
+	if err != nil {
+		return code, nil, err
+	}
+
+	role, err := roleFromRequest(request)
+	if err != nil {
+		return 404, nil, err
+	}
+
+	switch request.Method {
+	case POST:
+		var params struct {
+			UserID string `json:"user_id"`
+		}
+
+		err = json.NewDecoder(request.Body).Decode(&params)
+		if err != nil || params.UserID == "" {
+			return 400, nil, errors.New("user_id is required")
+		}
+
+		err = AddUserToRole(role.ID, params.UserID)
+	case DELETE:
+		err = RemoveUserFromRole(role.ID, mux.Vars(request)["user_id"])
+	default:
+		return 405, nil, http.ErrNotSupported
+	}
+
+	if err != nil {
+		return 500, nil, err
+	}
+
+	return 200, nil, nil
+}
+
+// HandleRoleInherits adds (POST {"role_id": 1}) or removes
+// (DELETE /{inherited_id}) roles inherited by a role
+func HandleRoleInherits(w http.ResponseWriter, request *http.Request) (int, interface{}, error) {
+	code, err := requireAdmin(request)
+	if err != nil {
+		return code, nil, err
+	}
+
+	role, err := roleFromRequest(request)
+	if err != nil {
+		return 404, nil, err
+	}
+
+	switch request.Method {
+	case POST:
+		var params struct {
+			RoleID uint `json:"role_id"`
+		}
+
+		err = json.NewDecoder(request.Body).Decode(&params)
+		if err != nil || params.RoleID == 0 {
+			return 400, nil, errors.New("role_id is required")
+		}
+
+		err = AddRoleInheritance(role.ID, params.RoleID)
+	case DELETE:
+		var inheritedID uint
+		fmt.Sscan(mux.Vars(request)["inherited_id"], &inheritedID)
+		err = RemoveRoleInheritance(role.ID, inheritedID)
+	default:
+		return 405, nil, http.ErrNotSupported
+	}
+
+	if err != nil {
+		return 500, nil, err
+	}
+
+	return 200, nil, nil
+}
+
+// AddDefaultRolePaths enables stored roles and routes admin requests
+// to manage roles and their members
+func (api *API) AddDefaultRolePaths() {
+	EnableRoles()
+
+	routes := map[string]simpleResponse{
+		"/admin/roles":                                     HandleRoles,
+		"/admin/roles/{id:[0-9]+}":                         HandleRole,
+		"/admin/roles/{id:[0-9]+}/users":                   HandleRoleUsers,
+		"/admin/roles/{id:[0-9]+}/users/{user_id}":         HandleRoleUsers,
+		"/admin/roles/{id:[0-9]+}/inherits":                HandleRoleInherits,
+		"/admin/roles/{id:[0-9]+}/inherits/{inherited_id}": HandleRoleInherits,
+	}
+
+	for path, handler := range routes {
+		handler := handler
+		api.Mux().HandleFunc(path, func(rw http.ResponseWriter, request *http.Request) {
+			api.renderJSON(rw, request, nil, OperationAdmin, handler)
+		})
+	}
+}
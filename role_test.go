@@ -0,0 +1,109 @@
+package goal_test
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/thomasdao/goal"
+)
+
+func TestStoredRoles(t *testing.T) {
+	setup()
+	defer tearDown()
+
+	goal.SharedAPI().AddDefaultRolePaths()
+
+	send := func(method string, path string, body string, cookie string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		if cookie != "" {
+			req.Header.Add("Cookie", cookie)
+		}
+		goal.SharedAPI().Mux().ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	// Bootstrap an admin from code
+	res := send("POST", "/auth/register", `{"username":"boss", "password": "secret-password"}`, "")
+	bossCookie := res.Header().Get("Set-Cookie")
+	var boss testuser
+	db.Where("username = ?", "boss").First(&boss)
+
+	adminRole, err := goal.CreateRole(goal.AdminRole)
+	if err != nil {
+		t.Fatal(err)
+	}
+	goal.AddUserToRole(adminRole.ID, fmt.Sprint(boss.ID))
+
+	// Admin manages roles through admin paths
+	res = send("POST", "/admin/roles", `{"name":"editor"}`, bossCookie)
+	if res.Code != 200 {
+		t.Fatal("Fail to create role", res.Body)
+	}
+
+	var editor goal.Role
+	db.Where("name = ?", "editor").First(&editor)
+
+	res = send("POST", "/admin/roles", `{"name":"chief"}`, bossCookie)
+	var chief goal.Role
+	db.Where("name = ?", "chief").First(&chief)
+
+	// chief inherits editor
+	path := fmt.Sprintf("/admin/roles/%v/inherits", chief.ID)
+	res = send("POST", path, fmt.Sprintf(`{"role_id":%v}`, editor.ID), bossCookie)
+	if res.Code != 200 {
+		t.Fatal("Fail to add inherited role", res.Body)
+	}
+
+	res = send("POST", "/auth/register", `{"username":"writer", "password": "secret-password"}`, "")
+	writerCookie := res.Header().Get("Set-Cookie")
+	var writer testuser
+	db.Where("username = ?", "writer").First(&writer)
+
+	// Non admin can't manage roles
+	res = send("POST", "/admin/roles", `{"name":"hacker"}`, writerCookie)
+	if res.Code != 403 {
+		t.Error("Only admin can manage roles", res.Code)
+	}
+
+	art := &article{Title: "Draft"}
+	art.Permission = goal.Permission{Read: `["editor"]`}
+	db.Create(art)
+	artPath := fmt.Sprint("/article/", art.ID)
+
+	res = send("GET", artPath, "", writerCookie)
+	if res.Code != 403 {
+		t.Error("Writer is not an editor yet", res.Code)
+	}
+
+	path = fmt.Sprintf("/admin/roles/%v/users", chief.ID)
+	body := []byte(fmt.Sprintf(`{"user_id":"%v"}`, writer.ID))
+	req, _ := http.NewRequest("POST", path, bytes.NewBuffer(body))
+	req.Header.Add("Cookie", bossCookie)
+	recorder := httptest.NewRecorder()
+	goal.SharedAPI().Mux().ServeHTTP(recorder, req)
+	if recorder.Code != 200 {
+		t.Fatal("Fail to add member", recorder.Body)
+	}
+
+	res = send("GET", artPath, "", writerCookie)
+	if res.Code != 200 {
+		t.Error("Chief should inherit editor role", res.Code, res.Body)
+	}
+
+	// Removing membership invalidates cached roles
+	path = fmt.Sprintf("/admin/roles/%v/users/%v", chief.ID, writer.ID)
+	res = send("DELETE", path, "", bossCookie)
+	if res.Code != 200 {
+		t.Fatal("Fail to remove member", res.Body)
+	}
+
+	res = send("GET", artPath, "", writerCookie)
+	if res.Code != 403 {
+		t.Error("Writer is no longer an editor", res.Code)
+	}
+}
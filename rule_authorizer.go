@@ -0,0 +1,306 @@
+package goal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Rule effects
+const (
+	EffectAllow = "allow"
+	EffectDeny  = "deny"
+)
+
+// Rule is a declarative authorization rule. Resource is a table name
+// or "*", Action is "read", "write" or "*". Condition is an
+// expression over attributes, e.g.
+//
+//	principal.org_id == resource.org_id && !resource.locked
+//
+// Available attributes: principal.<json field>, principal.id,
+// principal.roles, resource.<json field>, resource.table,
+// request.method, request.path and action
+type Rule struct {
+	Name      string `json:"name"`
+	Resource  string `json:"resource"`
+	Action    string `json:"action"`
+	Effect    string `json:"effect"`
+	Condition string `json:"condition"`
+
+	expr expression
+}
+
+// matches checks resource and action of the rule
+func (rule *Rule) matches(table string, action Action) bool {
+	if rule.Resource != "" && rule.Resource != "*" && rule.Resource != table {
+		return false
+	}
+
+	return rule.Action == "" || rule.Action == "*" || rule.Action == string(action)
+}
+
+// compile validates the rule and parses its condition
+func (rule *Rule) compile() error {
+	if rule.Effect != EffectAllow && rule.Effect != EffectDeny {
+		return fmt.Errorf("rule %s: invalid effect %q", rule.Name, rule.Effect)
+	}
+
+	expr, err := parseExpression(rule.Condition)
+	if err != nil {
+		return fmt.Errorf("rule %s: %v", rule.Name, err)
+	}
+
+	rule.expr = expr
+	return nil
+}
+
+// RuleAuthorizer evaluates rules in order, the first rule whose
+// condition holds decides. If no rule matches, the decision is
+// delegated to Fallback, or denied if Fallback is nil. A rule whose
+// condition can't be evaluated denies the request. Rules must be
+// added with NewRuleAuthorizer or AddRule, so they are compiled
+type RuleAuthorizer struct {
+	Rules        []*Rule
+	Fallback     Authorizer
+	LogDecisions bool
+
+	mutex sync.RWMutex
+}
+
+// NewRuleAuthorizer compiles rule conditions
+func NewRuleAuthorizer(rules []*Rule, fallback Authorizer) (*RuleAuthorizer, error) {
+	for _, rule := range rules {
+		err := rule.compile()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &RuleAuthorizer{Rules: rules, Fallback: fallback}, nil
+}
+
+// AddRule compiles the rule and appends it, invalid rules are rejected
+func (authorizer *RuleAuthorizer) AddRule(rule *Rule) error {
+	err := rule.compile()
+	if err != nil {
+		return err
+	}
+
+	authorizer.mutex.Lock()
+	defer authorizer.mutex.Unlock()
+
+	authorizer.Rules = append(authorizer.Rules, rule)
+	return nil
+}
+
+// LoadRuleAuthorizer reads rules from a json file in the format
+// {"log_decisions": true, "rules": [{"name": ..., "resource": ...}]}
+func LoadRuleAuthorizer(path string, fallback Authorizer) (*RuleAuthorizer, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config struct {
+		LogDecisions bool    `json:"log_decisions"`
+		Rules        []*Rule `json:"rules"`
+	}
+
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return nil, err
+	}
+
+	authorizer, err := NewRuleAuthorizer(config.Rules, fallback)
+	if err != nil {
+		return nil, err
+	}
+
+	authorizer.LogDecisions = config.LogDecisions
+	return authorizer, nil
+}
+
+// Authorize conforms to Authorizer interface
+func (authorizer *RuleAuthorizer) Authorize(
+	principal interface{}, action Action, resource interface{}, request *http.Request) error {
+	table := db.NewScope(resource).TableName()
+	attrs := ruleAttributes(principal, action, resource, table, request)
+
+	authorizer.mutex.RLock()
+	rules := authorizer.Rules
+	authorizer.mutex.RUnlock()
+
+	for _, rule := range rules {
+		if !rule.matches(table, action) {
+			continue
+		}
+
+		// A rule which can't be evaluated denies, so a broken deny
+		// rule doesn't let the request through
+		if rule.expr == nil {
+			fmt.Printf("rule %s: condition is not compiled\n", rule.Name)
+			authorizer.logDecision(principal, action, table, request, rule.Name, ErrUnauthorized)
+			return ErrUnauthorized
+		}
+
+		value, err := rule.expr.eval(attrs)
+		if err != nil {
+			fmt.Printf("rule %s: %v\n", rule.Name, err)
+			authorizer.logDecision(principal, action, table, request, rule.Name, ErrUnauthorized)
+			return ErrUnauthorized
+		}
+
+		if !truthy(value) {
+			continue
+		}
+
+		var result error
+		if rule.Effect != EffectAllow {
+			result = ErrUnauthorized
+		}
+
+		authorizer.logDecision(principal, action, table, request, rule.Name, result)
+		return result
+	}
+
+	var result error = ErrUnauthorized
+	if authorizer.Fallback != nil {
+		result = authorizer.Fallback.Authorize(principal, action, resource, request)
+	}
+
+	authorizer.logDecision(principal, action, table, request, "", result)
+	return result
+}
+
+// logDecision sends an "authz.decision" audit record
+func (authorizer *RuleAuthorizer) logDecision(
+	principal interface{}, action Action, table string, request *http.Request, rule string, result error) {
+	if !authorizer.LogDecisions {
+		return
+	}
+
+	effect := EffectAllow
+	if result != nil {
+		effect = EffectDeny
+	}
+
+	if rule == "" {
+		rule = "fallback"
+	}
+
+	record := &AuditRecord{
+		Event:  "authz.decision",
+		Detail: fmt.Sprintf("%s %s %s by rule %s", effect, action, table, rule),
+	}
+
+	if principal != nil && isUserModel(principal) {
+		record.Username = UserACLKey(userID(principal))
+	}
+
+	if request != nil {
+		record.IP = request.RemoteAddr
+	}
+
+	audit(record)
+}
+
+// ruleAttributes resolves attribute paths of a decision
+func ruleAttributes(
+	principal interface{}, action Action, resource interface{}, table string, request *http.Request) attributeResolver {
+	var principalValues, resourceValues map[string]interface{}
+
+	return func(path string) (interface{}, error) {
+		parts := strings.Split(path, ".")
+		switch parts[0] {
+		case "action":
+			return string(action), nil
+		case "request":
+			if request == nil || len(parts) != 2 {
+				return nil, nil
+			}
+
+			switch parts[1] {
+			case "method":
+				return request.Method, nil
+			case "path":
+				return request.URL.Path, nil
+			}
+			return nil, nil
+		case "principal":
+			if principal == nil || len(parts) < 2 {
+				return nil, nil
+			}
+
+			switch {
+			case len(parts) == 2 && parts[1] == "roles":
+				return contextUserRoles(requestContext(request), principal), nil
+			case len(parts) == 2 && parts[1] == "id" && isUserModel(principal):
+				return userID(principal), nil
+			}
+
+			if principalValues == nil {
+				principalValues = attributeValues(principal)
+			}
+			return lookupPath(principalValues, parts[1:]), nil
+		case "resource":
+			if len(parts) < 2 {
+				return nil, nil
+			}
+
+			if len(parts) == 2 && parts[1] == "table" {
+				return table, nil
+			}
+
+			if resourceValues == nil {
+				resourceValues = attributeValues(resource)
+			}
+			return lookupPath(resourceValues, parts[1:]), nil
+		}
+
+		return nil, fmt.Errorf("unknown attribute %s", path)
+	}
+}
+
+// attributeValues converts a model into a map keyed by json names
+func attributeValues(value interface{}) map[string]interface{} {
+	values := make(map[string]interface{})
+
+	data, err := json.Marshal(value)
+	if err == nil {
+		json.Unmarshal(data, &values)
+	}
+
+	return values
+}
+
+// lookupPath walks nested maps, keys are matched case insensitively
+func lookupPath(values map[string]interface{}, parts []string) interface{} {
+	var current interface{} = values
+	for _, part := range parts {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+
+		value, ok := m[part]
+		if !ok {
+			for key, v := range m {
+				if strings.EqualFold(key, part) {
+					value, ok = v, true
+					break
+				}
+			}
+		}
+
+		if !ok {
+			return nil
+		}
+		current = value
+	}
+
+	return current
+}
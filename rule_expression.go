@@ -0,0 +1,491 @@
+package goal
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// expression is a compiled rule condition
+type expression interface {
+	eval(attrs attributeResolver) (interface{}, error)
+}
+
+// attributeResolver looks up attribute paths like "principal.roles"
+type attributeResolver func(path string) (interface{}, error)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenNumber
+	tokenString
+	tokenOperator
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+}
+
+// tokenize splits a condition into tokens
+func tokenize(input string) ([]token, error) {
+	var tokens []token
+	runes := []rune(input)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '\'' || r == '"':
+			end := i + 1
+			var value strings.Builder
+			for end < len(runes) && runes[end] != r {
+				if runes[end] == '\\' && end+1 < len(runes) {
+					end++
+				}
+				value.WriteRune(runes[end])
+				end++
+			}
+
+			if end >= len(runes) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+
+			tokens = append(tokens, token{tokenString, value.String()})
+			i = end + 1
+		case unicode.IsDigit(r):
+			end := i
+			for end < len(runes) && (unicode.IsDigit(runes[end]) || runes[end] == '.') {
+				end++
+			}
+
+			tokens = append(tokens, token{tokenNumber, string(runes[i:end])})
+			i = end
+		case unicode.IsLetter(r) || r == '_':
+			end := i
+			for end < len(runes) && (unicode.IsLetter(runes[end]) || unicode.IsDigit(runes[end]) ||
+				runes[end] == '_' || runes[end] == '.') {
+				end++
+			}
+
+			tokens = append(tokens, token{tokenIdent, string(runes[i:end])})
+			i = end
+		default:
+			if i+1 < len(runes) {
+				two := string(runes[i : i+2])
+				switch two {
+				case "||", "&&", "==", "!=", "<=", ">=":
+					tokens = append(tokens, token{tokenOperator, two})
+					i += 2
+					continue
+				}
+			}
+
+			switch r {
+			case '!', '<', '>', '(', ')', '[', ']', ',':
+				tokens = append(tokens, token{tokenOperator, string(r)})
+				i++
+			default:
+				return nil, fmt.Errorf("unexpected character %q at %d", r, i)
+			}
+		}
+	}
+
+	return append(tokens, token{tokenEOF, ""}), nil
+}
+
+// parser is a recursive descent parser with precedence
+// || < && < ! < comparison
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// parseExpression compiles a condition. Empty condition always matches
+func parseExpression(input string) (expression, error) {
+	if strings.TrimSpace(input) == "" {
+		return literal{true}, nil
+	}
+
+	tokens, err := tokenize(input)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.peek().kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %q", p.peek().value)
+	}
+
+	return expr, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) accept(op string) bool {
+	t := p.peek()
+	if t.kind == tokenOperator && t.value == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(op string) error {
+	if !p.accept(op) {
+		return fmt.Errorf("expected %q", op)
+	}
+	return nil
+}
+
+func (p *parser) parseOr() (expression, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.accept("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = logical{"||", left, right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseAnd() (expression, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.accept("&&") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = logical{"&&", left, right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseNot() (expression, error) {
+	if p.accept("!") {
+		expr, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return not{expr}, nil
+	}
+
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (expression, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	t := p.peek()
+	op := ""
+	switch {
+	case t.kind == tokenOperator:
+		switch t.value {
+		case "==", "!=", "<", "<=", ">", ">=":
+			op = t.value
+		}
+	case t.kind == tokenIdent && t.value == "in":
+		op = "in"
+	}
+
+	if op == "" {
+		return left, nil
+	}
+
+	p.next()
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	return comparison{op, left, right}, nil
+}
+
+func (p *parser) parseOperand() (expression, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenString:
+		return literal{t.value}, nil
+	case tokenNumber:
+		number, err := strconv.ParseFloat(t.value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", t.value)
+		}
+		return literal{number}, nil
+	case tokenIdent:
+		switch t.value {
+		case "true":
+			return literal{true}, nil
+		case "false":
+			return literal{false}, nil
+		case "null", "nil":
+			return literal{nil}, nil
+		}
+		return attribute{t.value}, nil
+	case tokenOperator:
+		switch t.value {
+		case "(":
+			expr, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return expr, p.expect(")")
+		case "[":
+			var items listLiteral
+			if p.accept("]") {
+				return items, nil
+			}
+
+			for {
+				item, err := p.parseOperand()
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, item)
+
+				if p.accept("]") {
+					return items, nil
+				}
+
+				err = p.expect(",")
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+	case tokenEOF:
+		return nil, errors.New("unexpected end of condition")
+	}
+
+	return nil, fmt.Errorf("unexpected %q", t.value)
+}
+
+type literal struct {
+	value interface{}
+}
+
+func (expr literal) eval(attrs attributeResolver) (interface{}, error) {
+	return expr.value, nil
+}
+
+type attribute struct {
+	path string
+}
+
+func (expr attribute) eval(attrs attributeResolver) (interface{}, error) {
+	return attrs(expr.path)
+}
+
+type listLiteral []expression
+
+func (expr listLiteral) eval(attrs attributeResolver) (interface{}, error) {
+	values := make([]interface{}, 0, len(expr))
+	for _, item := range expr {
+		value, err := item.eval(attrs)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+
+	return values, nil
+}
+
+type not struct {
+	expr expression
+}
+
+func (expr not) eval(attrs attributeResolver) (interface{}, error) {
+	value, err := expr.expr.eval(attrs)
+	if err != nil {
+		return nil, err
+	}
+
+	return !truthy(value), nil
+}
+
+type logical struct {
+	op          string
+	left, right expression
+}
+
+func (expr logical) eval(attrs attributeResolver) (interface{}, error) {
+	left, err := expr.left.eval(attrs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Short circuit
+	if expr.op == "||" && truthy(left) {
+		return true, nil
+	}
+
+	if expr.op == "&&" && !truthy(left) {
+		return false, nil
+	}
+
+	right, err := expr.right.eval(attrs)
+	if err != nil {
+		return nil, err
+	}
+
+	return truthy(right), nil
+}
+
+type comparison struct {
+	op          string
+	left, right expression
+}
+
+func (expr comparison) eval(attrs attributeResolver) (interface{}, error) {
+	left, err := expr.left.eval(attrs)
+	if err != nil {
+		return nil, err
+	}
+
+	right, err := expr.right.eval(attrs)
+	if err != nil {
+		return nil, err
+	}
+
+	switch expr.op {
+	case "==":
+		return valuesEqual(left, right), nil
+	case "!=":
+		return !valuesEqual(left, right), nil
+	case "in":
+		items := reflect.ValueOf(right)
+		if right == nil || (items.Kind() != reflect.Slice && items.Kind() != reflect.Array) {
+			return false, nil
+		}
+
+		for i := 0; i < items.Len(); i++ {
+			if valuesEqual(left, items.Index(i).Interface()) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	// Ordering is defined between numbers or between strings
+	if a, ok := toNumber(left); ok {
+		if b, ok := toNumber(right); ok {
+			return compareOrder(expr.op, a < b, a == b), nil
+		}
+	}
+
+	a, aok := left.(string)
+	b, bok := right.(string)
+	if aok && bok {
+		return compareOrder(expr.op, a < b, a == b), nil
+	}
+
+	return false, nil
+}
+
+func compareOrder(op string, less bool, equal bool) bool {
+	switch op {
+	case "<":
+		return less
+	case "<=":
+		return less || equal
+	case ">":
+		return !less && !equal
+	default:
+		return !less
+	}
+}
+
+// truthy converts a value to bool: false, nil, zero and empty
+// values are false
+func truthy(value interface{}) bool {
+	if value == nil {
+		return false
+	}
+
+	if b, ok := value.(bool); ok {
+		return b
+	}
+
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map, reflect.String, reflect.Array:
+		return v.Len() > 0
+	}
+
+	if n, ok := toNumber(value); ok {
+		return n != 0
+	}
+
+	return true
+}
+
+func toNumber(value interface{}) (float64, bool) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+
+	return 0, false
+}
+
+// valuesEqual compares numbers numerically, other scalars by their
+// string form, so principal.id == resource.author_id works whatever
+// the column types are
+func valuesEqual(a interface{}, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+
+	if x, ok := toNumber(a); ok {
+		if y, ok := toNumber(b); ok {
+			return x == y
+		}
+	}
+
+	if _, ok := a.(bool); ok {
+		return a == b
+	}
+
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
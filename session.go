@@ -1,9 +1,12 @@
 package goal
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"reflect"
+	"time"
 
 	"github.com/gorilla/sessions"
 )
@@ -14,6 +17,10 @@ const (
 
 	// SessionKey is default key for user object
 	SessionKey = "goal.UserSessionKey"
+
+	// SessionIssuedKey stores the time session was issued, in
+	// nanoseconds, used to invalidate older sessions of a user
+	SessionIssuedKey = "goal.UserSessionIssued"
 )
 
 // SharedSessionStore used to generate session for multiple requests
@@ -52,14 +59,56 @@ func SetUserSession(w http.ResponseWriter, req *http.Request, user interface{})
 
 	// Set some session values.
 	session.Values[SessionKey] = scope.PrimaryKeyValue()
+	session.Values[SessionIssuedKey] = time.Now().UnixNano()
 
 	// Save it before we write to the response/return from the handler.
 	err = session.Save(req, w)
+	if err == nil {
+		setCurrentUser(req, user, nil)
+	}
+
 	return err
 }
 
-// GetCurrentUser returns current user based on the request header
+// GetCurrentUser returns current user based on the request header.
+// If api keys are enabled and the request has APIKeyHeader, the
+// matching *APIKey is returned instead of a user. Inside goal
+// handlers the result is memoized on the request context
 func GetCurrentUser(req *http.Request) (interface{}, error) {
+	entry := currentUserOf(req)
+	if entry == nil {
+		return loadCurrentUser(req)
+	}
+
+	entry.mutex.Lock()
+	defer entry.mutex.Unlock()
+
+	if !entry.resolved {
+		entry.user, entry.err = loadCurrentUser(req)
+		entry.resolved = true
+	}
+
+	return entry.user, entry.err
+}
+
+// loadCurrentUser loads current user from api key or session
+func loadCurrentUser(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+	if apiKeysEnabled {
+		if plaintext := req.Header.Get(APIKeyHeader); plaintext != "" {
+			key, err := authenticateAPIKey(ctx, plaintext)
+			if err != nil {
+				return nil, err
+			}
+
+			return key, nil
+		}
+	}
+
+	if SharedSessionStore == nil {
+		return nil, errors.New("session store is not initialized")
+	}
+
 	session, err := SharedSessionStore.Get(req, SessionName)
 	if err != nil {
 		return nil, err
@@ -76,15 +125,22 @@ func GetCurrentUser(req *http.Request) (interface{}, error) {
 		return nil, err
 	}
 
+	// Reject sessions issued before user's sessions were invalidated
+	issued, _ := session.Values[SessionIssuedKey].(int64)
+	if issued < sessionsValidAfter(ctx, TableName(user), userID) {
+		return nil, errors.New("session expired")
+	}
+
 	// Load user from Cache or from database
 	exists := false
-	if SharedCache != nil {
+	if SharedCache != nil && !cacheOptions(user).Disabled {
 		cacheKey := DefaultCacheKey(TableName(user), userID)
-		exists, err = SharedCache.Exists(cacheKey)
+		exists, err = SharedCache.Exists(ctx, cacheKey)
 		if err == nil && exists {
-			err = SharedCache.Get(cacheKey, user)
+			err = SharedCache.Get(ctx, cacheKey, user)
 
 			if err == nil {
+				touchResource(ctx, cacheKey, user)
 				return user, nil
 			}
 		}
@@ -92,15 +148,46 @@ func GetCurrentUser(req *http.Request) (interface{}, error) {
 
 	// If data not exists in Redis, load from database
 	if !exists {
-		err = db.First(user, userID).Error
+		// Concurrent requests of the same user share one query
+		cacheKey := DefaultCacheKey(TableName(user), userID)
+		err = userFlight.load(ctx, cacheKey, user, func(dst interface{}) error {
+			return contextDB(ctx).First(dst, userID).Error
+		})
 		return user, err
 	}
 
 	return nil, errors.New("invalid session data")
 }
 
+func sessionsValidAfterKey(name string, id interface{}) string {
+	return stateKey(fmt.Sprintf("goal:session:valid_after:%v:%v", name, id))
+}
+
+// sessionsValidAfter returns the time, in nanoseconds, before which
+// sessions of the user are not accepted
+func sessionsValidAfter(ctx context.Context, name string, id interface{}) int64 {
+	var validAfter int64
+	err := stateStore().Get(ctx, sessionsValidAfterKey(name, id), &validAfter)
+	if err != nil {
+		return 0
+	}
+
+	return validAfter
+}
+
+// InvalidateUserSessions makes all sessions issued so far for the user
+// invalid, e.g. after password is changed. Sessions are tracked in
+// SharedCache, or in memory of current process if it is not registered.
+// The marker never expires, whatever the default TTL of the cacher
+func InvalidateUserSessions(user interface{}) error {
+	scope := db.NewScope(user)
+	key := sessionsValidAfterKey(scope.TableName(), scope.PrimaryKeyValue())
+	return setWithExactTTL(context.Background(), stateStore(), key, time.Now().UnixNano(), 0)
+}
+
 // ClearUserSession removes the current user from session
 func ClearUserSession(w http.ResponseWriter, req *http.Request) error {
 	http.SetCookie(w, nil)
+	setCurrentUser(req, nil, ErrNotLoggedIn)
 	return nil
 }
@@ -0,0 +1,86 @@
+package goal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+)
+
+// flightGroup coalesces concurrent loads of the same key, so only
+// one of them queries the database
+type flightGroup struct {
+	mutex sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	wg   sync.WaitGroup
+	dups int
+	data []byte
+	err  error
+}
+
+var errFlightPanic = errors.New("load of shared value panicked")
+
+// load runs fn to fill dst, concurrent callers of the same key wait
+// for it and receive a json copy of the value, so callers never share
+// the same object. If the caller that loaded the value was canceled,
+// fn runs again for waiting callers
+func (group *flightGroup) load(ctx context.Context, key string, dst interface{}, fn func(dst interface{}) error) error {
+	group.mutex.Lock()
+	if group.calls == nil {
+		group.calls = make(map[string]*flightCall)
+	}
+
+	if call, ok := group.calls[key]; ok {
+		call.dups++
+		group.mutex.Unlock()
+		call.wg.Wait()
+
+		if (call.err == context.Canceled || call.err == context.DeadlineExceeded) && ctx.Err() == nil {
+			return fn(dst)
+		}
+
+		if call.err != nil {
+			return call.err
+		}
+
+		return json.Unmarshal(call.data, dst)
+	}
+
+	call := &flightCall{err: errFlightPanic}
+	call.wg.Add(1)
+	group.calls[key] = call
+	group.mutex.Unlock()
+
+	// Waiting callers are released even if fn panics
+	defer func() {
+		group.mutex.Lock()
+		if group.calls[key] == call {
+			delete(group.calls, key)
+		}
+		group.mutex.Unlock()
+		call.wg.Done()
+	}()
+
+	err := fn(dst)
+
+	// No caller can join once the call is removed
+	group.mutex.Lock()
+	delete(group.calls, key)
+	dups := call.dups
+	group.mutex.Unlock()
+
+	call.err = err
+	if err == nil && dups > 0 {
+		call.data, call.err = json.Marshal(dst)
+	}
+
+	return err
+}
+
+var (
+	readFlight flightGroup
+	userFlight flightGroup
+)
@@ -0,0 +1,105 @@
+package goal_test
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/thomasdao/goal"
+)
+
+// countQueries counts slow queries of the table until the returned
+// function is called
+func countQueries(table string, delay time.Duration) (*int32, func()) {
+	var count int32
+	db.Callback().Query().Before("gorm:query").Register("test:count_queries:"+table, func(scope *gorm.Scope) {
+		if scope.TableName() == table {
+			atomic.AddInt32(&count, 1)
+			time.Sleep(delay)
+		}
+	})
+
+	return &count, func() {
+		db.Callback().Query().Remove("test:count_queries:" + table)
+	}
+}
+
+func TestReadCoalesced(t *testing.T) {
+	setup()
+	defer tearDown()
+
+	user := &testuser{Name: "Thomas", Age: 28}
+	db.Create(user)
+	goal.SharedCache.Delete(context.Background(), goal.CacheKey(user))
+
+	count, done := countQueries("testuser", 100*time.Millisecond)
+	defer done()
+
+	var wg sync.WaitGroup
+	var failed int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := http.Get(idURL(user.ID))
+			if err != nil || res.StatusCode != 200 {
+				atomic.AddInt32(&failed, 1)
+				return
+			}
+			res.Body.Close()
+		}()
+	}
+	wg.Wait()
+
+	if failed > 0 {
+		t.Error("Concurrent reads should succeed", failed)
+	}
+
+	if *count != 1 {
+		t.Error("Concurrent reads should share one query", *count)
+	}
+}
+
+func TestNegativeCache(t *testing.T) {
+	setup()
+	defer tearDown()
+
+	count, done := countQueries("testuser", 0)
+	defer done()
+
+	for i := 0; i < 3; i++ {
+		res, err := http.Get(idURL(42))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		res.Body.Close()
+
+		if res.StatusCode != 404 {
+			t.Error("Missing record should return 404", res.StatusCode)
+		}
+	}
+
+	if *count != 1 {
+		t.Error("Missing record should be queried once", *count)
+	}
+
+	// Creating the record clears the marker
+	db.Create(&testuser{ID: 42, Name: "Thomas"})
+	goal.SharedCache.Delete(context.Background(), goal.DefaultCacheKey("testuser", 42))
+
+	res, err := http.Get(idURL(42))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	res.Body.Close()
+
+	if res.StatusCode != 200 {
+		t.Error("Created record should be found", res.StatusCode)
+	}
+}
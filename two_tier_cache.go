@@ -0,0 +1,315 @@
+package goal
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// TwoTierCache layers a small local cache (L1), like MemoryCache, in
+// front of a shared cache (L2), like RedisCache. Values found in L2 are
+// promoted to L1, Set writes to both tiers and Delete removes the key
+// from both. Register an InvalidationBus with L1 when several
+// instances share L2
+type TwoTierCache struct {
+	L1 Cacher
+	L2 Cacher
+
+	// L1TTL caps expiry of local copies, L2TTL is the expiry of Set in
+	// L2. Zero uses the default of the tier
+	L1TTL time.Duration
+	L2TTL time.Duration
+
+	// L2OnlyPrefixes are key prefixes, after the cache namespace, never
+	// stored in L1. Keys of login attempts, session invalidation and
+	// role generation are always kept out of L1, they are shared by
+	// all instances and must not be evicted
+	L2OnlyPrefixes []string
+
+	l1Hits, l1Misses, l2Hits, l2Misses int64
+}
+
+// CacheStats counts hits and misses of each tier of TwoTierCache
+type CacheStats struct {
+	L1Hits   int64 `json:"l1_hits"`
+	L1Misses int64 `json:"l1_misses"`
+	L2Hits   int64 `json:"l2_hits"`
+	L2Misses int64 `json:"l2_misses"`
+}
+
+// NewTwoTierCache layers l1 in front of l2
+func NewTwoTierCache(l1 Cacher, l2 Cacher) *TwoTierCache {
+	return &TwoTierCache{L1: l1, L2: l2}
+}
+
+// Stats returns hits and misses counted so far
+func (cache *TwoTierCache) Stats() CacheStats {
+	return CacheStats{
+		L1Hits:   atomic.LoadInt64(&cache.l1Hits),
+		L1Misses: atomic.LoadInt64(&cache.l1Misses),
+		L2Hits:   atomic.LoadInt64(&cache.l2Hits),
+		L2Misses: atomic.LoadInt64(&cache.l2Misses),
+	}
+}
+
+// ResetStats sets all counters to zero
+func (cache *TwoTierCache) ResetStats() {
+	atomic.StoreInt64(&cache.l1Hits, 0)
+	atomic.StoreInt64(&cache.l1Misses, 0)
+	atomic.StoreInt64(&cache.l2Hits, 0)
+	atomic.StoreInt64(&cache.l2Misses, 0)
+}
+
+// local checks if the key may be stored in L1
+func (cache *TwoTierCache) local(key string) bool {
+	if isStateKey(key) {
+		return false
+	}
+
+	key = strings.TrimPrefix(key, CacheKeyPrefix())
+	for _, prefix := range cache.L2OnlyPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// l1TTL returns expiry of a local copy of a value stored for ttl
+func (cache *TwoTierCache) l1TTL(ttl time.Duration) time.Duration {
+	if cache.L1TTL > 0 && (ttl <= 0 || cache.L1TTL < ttl) {
+		return cache.L1TTL
+	}
+
+	return ttl
+}
+
+// Get conforms to Cacher interface
+func (cache *TwoTierCache) Get(ctx context.Context, key string, val interface{}) error {
+	local := cache.local(key)
+	if local {
+		if cache.L1.Get(ctx, key, val) == nil {
+			atomic.AddInt64(&cache.l1Hits, 1)
+			return nil
+		}
+		atomic.AddInt64(&cache.l1Misses, 1)
+	}
+
+	err := cache.L2.Get(ctx, key, val)
+	if err != nil {
+		if err == ErrCacheMiss {
+			atomic.AddInt64(&cache.l2Misses, 1)
+		}
+		return err
+	}
+
+	atomic.AddInt64(&cache.l2Hits, 1)
+	if local {
+		reportCacheError("set", key, setWithTTL(ctx, cache.L1, key, val, cache.L1TTL))
+	}
+
+	return nil
+}
+
+// Set conforms to Cacher interface, L2 keeps the value for L2TTL, or
+// its default TTL if L2TTL is zero
+func (cache *TwoTierCache) Set(ctx context.Context, key string, val interface{}) error {
+	if cache.L2TTL > 0 {
+		return cache.SetWithTTL(ctx, key, val, cache.L2TTL)
+	}
+
+	return cache.store(ctx, key, val, 0, cache.L2.Set(ctx, key, val))
+}
+
+// SetWithTTL conforms to ExpiringCacher interface, zero ttl means no
+// expiry in L2
+func (cache *TwoTierCache) SetWithTTL(ctx context.Context, key string, val interface{}, ttl time.Duration) error {
+	return cache.store(ctx, key, val, ttl, setWithExactTTL(ctx, cache.L2, key, val, ttl))
+}
+
+// store writes the local copy once L2 is written, the local copy is
+// removed if writing L2 failed
+func (cache *TwoTierCache) store(ctx context.Context, key string, val interface{}, ttl time.Duration, err error) error {
+	if err != nil {
+		cache.L1.Delete(ctx, key)
+		return err
+	}
+
+	if !cache.local(key) {
+		return nil
+	}
+
+	return setWithTTL(ctx, cache.L1, key, val, cache.l1TTL(ttl))
+}
+
+// Touch conforms to ExpiringCacher interface
+func (cache *TwoTierCache) Touch(ctx context.Context, key string, ttl time.Duration) error {
+	var err error
+	if expiring, ok := cache.L2.(ExpiringCacher); ok {
+		err = expiring.Touch(ctx, key, ttl)
+	}
+
+	if expiring, ok := cache.L1.(ExpiringCacher); ok && cache.local(key) {
+		expiring.Touch(ctx, key, cache.l1TTL(ttl))
+	}
+
+	return err
+}
+
+// Delete conforms to Cacher interface
+func (cache *TwoTierCache) Delete(ctx context.Context, key string) error {
+	err := cache.L2.Delete(ctx, key)
+	l1Err := cache.L1.Delete(ctx, key)
+	if err == nil {
+		err = l1Err
+	}
+
+	return err
+}
+
+// Exists conforms to Cacher interface
+func (cache *TwoTierCache) Exists(ctx context.Context, key string) (bool, error) {
+	if cache.local(key) {
+		exists, err := cache.L1.Exists(ctx, key)
+		if err == nil && exists {
+			return true, nil
+		}
+	}
+
+	return cache.L2.Exists(ctx, key)
+}
+
+// GetMulti conforms to BatchCacher interface. Keys missing from L1 are
+// read from L2 in one batch and promoted
+func (cache *TwoTierCache) GetMulti(ctx context.Context, keys []string, vals []interface{}) ([]bool, error) {
+	found := make([]bool, len(keys))
+
+	var localKeys []string
+	var localIndexes []int
+	for i, key := range keys {
+		if cache.local(key) {
+			localKeys = append(localKeys, key)
+			localIndexes = append(localIndexes, i)
+		}
+	}
+
+	if len(localKeys) > 0 {
+		localVals := make([]interface{}, len(localKeys))
+		for j, i := range localIndexes {
+			localVals[j] = vals[i]
+		}
+
+		hits, _ := getMulti(ctx, cache.L1, localKeys, localVals)
+		for j, hit := range hits {
+			found[localIndexes[j]] = hit
+			if hit {
+				atomic.AddInt64(&cache.l1Hits, 1)
+			} else {
+				atomic.AddInt64(&cache.l1Misses, 1)
+			}
+		}
+	}
+
+	var missKeys []string
+	var missVals []interface{}
+	var missIndexes []int
+	for i, key := range keys {
+		if !found[i] {
+			missKeys = append(missKeys, key)
+			missVals = append(missVals, vals[i])
+			missIndexes = append(missIndexes, i)
+		}
+	}
+
+	if len(missKeys) == 0 {
+		return found, nil
+	}
+
+	hits, err := getMulti(ctx, cache.L2, missKeys, missVals)
+	if err != nil {
+		return found, err
+	}
+
+	var promoteKeys []string
+	var promoteVals []interface{}
+	for j, hit := range hits {
+		if !hit {
+			atomic.AddInt64(&cache.l2Misses, 1)
+			continue
+		}
+
+		atomic.AddInt64(&cache.l2Hits, 1)
+		found[missIndexes[j]] = true
+		if cache.local(missKeys[j]) {
+			promoteKeys = append(promoteKeys, missKeys[j])
+			promoteVals = append(promoteVals, missVals[j])
+		}
+	}
+
+	if len(promoteKeys) > 0 {
+		err = setMulti(ctx, cache.L1, promoteKeys, promoteVals, cache.L1TTL)
+		reportCacheError("set", strings.Join(promoteKeys, " "), err)
+	}
+
+	return found, nil
+}
+
+// SetMulti conforms to BatchCacher interface
+func (cache *TwoTierCache) SetMulti(ctx context.Context, keys []string, vals []interface{}, ttl time.Duration) error {
+	if ttl <= 0 {
+		ttl = cache.L2TTL
+	}
+
+	var localKeys []string
+	var localVals []interface{}
+	for i, key := range keys {
+		if cache.local(key) {
+			localKeys = append(localKeys, key)
+			localVals = append(localVals, vals[i])
+		}
+	}
+
+	err := setMulti(ctx, cache.L2, keys, vals, ttl)
+	if err != nil {
+		deleteMulti(ctx, cache.L1, localKeys)
+		return err
+	}
+
+	if len(localKeys) == 0 {
+		return nil
+	}
+
+	return setMulti(ctx, cache.L1, localKeys, localVals, cache.l1TTL(ttl))
+}
+
+// DeleteMulti conforms to BatchCacher interface
+func (cache *TwoTierCache) DeleteMulti(ctx context.Context, keys []string) error {
+	err := deleteMulti(ctx, cache.L2, keys)
+	l1Err := deleteMulti(ctx, cache.L1, keys)
+	if err == nil {
+		err = l1Err
+	}
+
+	return err
+}
+
+// ClearPrefix conforms to PrefixClearer interface, both tiers must
+// support it
+func (cache *TwoTierCache) ClearPrefix(ctx context.Context, prefix string) error {
+	l1, ok1 := cache.L1.(PrefixClearer)
+	l2, ok2 := cache.L2.(PrefixClearer)
+	if !ok1 || !ok2 {
+		return errors.New("cacher can't clear keys by prefix")
+	}
+
+	err := l2.ClearPrefix(ctx, prefix)
+	l1Err := l1.ClearPrefix(ctx, prefix)
+	if err == nil {
+		err = l1Err
+	}
+
+	return err
+}
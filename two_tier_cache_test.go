@@ -0,0 +1,107 @@
+package goal_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/thomasdao/goal"
+)
+
+func TestTwoTierCache(t *testing.T) {
+	ctx := context.Background()
+	l1 := goal.NewMemoryCache(10, 0)
+	l2 := goal.NewMemoryCache(0, 0)
+	cache := goal.NewTwoTierCache(l1, l2)
+
+	// Write through
+	cache.Set(ctx, "testuser:1", &testuser{ID: 1, Name: "Thomas"})
+	if exists, _ := l2.Exists(ctx, "testuser:1"); !exists {
+		t.Error("Set should write to L2")
+	}
+
+	var user testuser
+	if err := cache.Get(ctx, "testuser:1", &user); err != nil || user.Name != "Thomas" {
+		t.Error("Value should be read from L1", user, err)
+	}
+
+	// Read through promotion
+	l2.Set(ctx, "testuser:2", &testuser{ID: 2, Name: "Dao"})
+	if err := cache.Get(ctx, "testuser:2", &user); err != nil || user.Name != "Dao" {
+		t.Error("Value should be read from L2", user, err)
+	}
+
+	if exists, _ := l1.Exists(ctx, "testuser:2"); !exists {
+		t.Error("Value found in L2 should be promoted to L1")
+	}
+
+	if err := cache.Get(ctx, "missing", &user); err != goal.ErrCacheMiss {
+		t.Error("Missing key should return ErrCacheMiss", err)
+	}
+
+	stats := cache.Stats()
+	expected := goal.CacheStats{L1Hits: 1, L1Misses: 2, L2Hits: 1, L2Misses: 1}
+	if stats != expected {
+		t.Error("Invalid stats", stats)
+	}
+
+	cache.Delete(ctx, "testuser:2")
+	exists1, _ := l1.Exists(ctx, "testuser:2")
+	exists2, _ := l2.Exists(ctx, "testuser:2")
+	if exists1 || exists2 {
+		t.Error("Delete should remove the key from both tiers")
+	}
+
+	// Shared state is never stored locally
+	cache.Set(ctx, "goal:login:user:thomas", 3)
+	if exists, _ := l1.Exists(ctx, "goal:login:user:thomas"); exists {
+		t.Error("Login attempts should only be stored in L2")
+	}
+}
+
+func TestTwoTierCacheTTL(t *testing.T) {
+	ctx := context.Background()
+	l1 := goal.NewMemoryCache(0, 0)
+	l2 := goal.NewMemoryCache(0, 0)
+	cache := goal.NewTwoTierCache(l1, l2)
+	cache.L1TTL = 50 * time.Millisecond
+	cache.L2TTL = 200 * time.Millisecond
+
+	cache.Set(ctx, "testuser:1", &testuser{ID: 1})
+	time.Sleep(100 * time.Millisecond)
+
+	exists1, _ := l1.Exists(ctx, "testuser:1")
+	exists2, _ := l2.Exists(ctx, "testuser:1")
+	if exists1 || !exists2 {
+		t.Error("Local copy should expire before L2", exists1, exists2)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if exists, _ := cache.Exists(ctx, "testuser:1"); exists {
+		t.Error("Value should expire after L2TTL")
+	}
+}
+
+func TestTwoTierCacheRead(t *testing.T) {
+	setup()
+	defer tearDown()
+
+	cache := goal.NewTwoTierCache(goal.NewMemoryCache(0, 0), goal.SharedCache)
+	shared := goal.SharedCache
+	goal.RegisterCacher(cache)
+	defer goal.RegisterCacher(shared)
+
+	user := &testuser{Name: "Thomas", Age: 28}
+	db.Create(user)
+	cache.ResetStats()
+
+	for i := 0; i < 3; i++ {
+		if code, _ := readUser(t, user.ID); code != 200 {
+			t.Error("Read should succeed", code)
+		}
+	}
+
+	if stats := cache.Stats(); stats.L1Hits != 3 || stats.L2Hits != 0 {
+		t.Error("Reads should be served by L1", stats)
+	}
+}
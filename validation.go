@@ -0,0 +1,348 @@
+package goal
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// ValidationErrors maps json field names to error messages. It is
+// returned with 422 status as data of the error response
+type ValidationErrors map[string][]string
+
+// Add appends a message for the field
+func (errs ValidationErrors) Add(field string, message string) {
+	errs[field] = append(errs[field], message)
+}
+
+func (errs ValidationErrors) Error() string {
+	fields := make([]string, 0, len(errs))
+	for field := range errs {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	messages := make([]string, 0, len(fields))
+	for _, field := range fields {
+		messages = append(messages, fmt.Sprintf("%s %s", field, strings.Join(errs[field], ", ")))
+	}
+
+	return "validation failed: " + strings.Join(messages, "; ")
+}
+
+// Validator lets a model add custom validation. Return
+// ValidationErrors to report field errors
+type Validator interface {
+	Validate(*Trigger) error
+}
+
+var regexps sync.Map
+
+func compileRegexp(pattern string) (*regexp.Regexp, error) {
+	if re, ok := regexps.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	regexps.Store(pattern, re)
+	return re, nil
+}
+
+// jsonName returns the key of a field in json
+func jsonName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "" || name == "-" {
+		return field.Name
+	}
+
+	return name
+}
+
+// size returns length of strings and collections, or the value of numbers
+func size(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(v.String())), true
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return float64(v.Len()), true
+	}
+
+	return toNumber(v.Interface())
+}
+
+func isLength(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return true
+	}
+	return false
+}
+
+// ValidateModel checks validate tags of the model, e.g.
+//
+//	Email string `validate:"required,email,unique"`
+//
+// Supported rules are required, min=N, max=N, len=N, email,
+// enum=a|b|c, unique and regexp=pattern, which must be the last rule.
+// Empty strings, collections and nil pointers are only checked by
+// required. It returns ValidationErrors or nil
+func ValidateModel(ctx context.Context, value interface{}) error {
+	errs := make(ValidationErrors)
+	validateStruct(ctx, value, reflect.Indirect(reflect.ValueOf(value)), errs)
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+func validateStruct(ctx context.Context, model interface{}, v reflect.Value, errs ValidationErrors) {
+	if v.Kind() != reflect.Struct {
+		return
+	}
+
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			validateStruct(ctx, model, v.Field(i), errs)
+			continue
+		}
+
+		tag := field.Tag.Get("validate")
+		if tag == "" || tag == "-" {
+			continue
+		}
+
+		validateField(ctx, model, field, v.Field(i), tag, errs)
+	}
+}
+
+func validateField(
+	ctx context.Context, model interface{}, field reflect.StructField,
+	value reflect.Value, tag string, errs ValidationErrors) {
+	name := jsonName(field)
+
+	// Pointers are checked by their value, a non nil pointer to
+	// zero value is present
+	empty, present := false, false
+	if value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			empty = true
+		} else {
+			value, present = value.Elem(), true
+		}
+	}
+
+	if !empty && isLength(value) {
+		empty = value.Len() == 0
+		if value.Kind() == reflect.String {
+			empty = strings.TrimSpace(value.String()) == ""
+		}
+	}
+
+	for len(tag) > 0 {
+		var rule string
+		if strings.HasPrefix(tag, "regexp=") {
+			rule, tag = tag, ""
+		} else if i := strings.Index(tag, ","); i >= 0 {
+			rule, tag = tag[:i], tag[i+1:]
+		} else {
+			rule, tag = tag, ""
+		}
+
+		key, arg := rule, ""
+		if i := strings.Index(rule, "="); i >= 0 {
+			key, arg = rule[:i], rule[i+1:]
+		}
+
+		if key == "required" {
+			if empty || (!present && isZeroValue(value)) {
+				errs.Add(name, "is required")
+				return
+			}
+			continue
+		}
+
+		if empty {
+			continue
+		}
+
+		if message := checkRule(ctx, model, field, value, key, arg); message != "" {
+			errs.Add(name, message)
+		}
+	}
+}
+
+func isZeroValue(v reflect.Value) bool {
+	return reflect.DeepEqual(v.Interface(), reflect.Zero(v.Type()).Interface())
+}
+
+// checkRule returns error message of a failed rule
+func checkRule(
+	ctx context.Context, model interface{}, field reflect.StructField,
+	value reflect.Value, key string, arg string) string {
+	unit := ""
+	if value.Kind() == reflect.String {
+		unit = " characters"
+	} else if isLength(value) {
+		unit = " items"
+	}
+
+	switch key {
+	case "min", "max", "len":
+		limit, err := strconv.ParseFloat(arg, 64)
+		n, ok := size(value)
+		if err != nil || !ok {
+			return fmt.Sprintf("has invalid rule %s", key)
+		}
+
+		switch {
+		case key == "min" && n < limit:
+			return fmt.Sprintf("must be at least %s%s", arg, unit)
+		case key == "max" && n > limit:
+			return fmt.Sprintf("must be at most %s%s", arg, unit)
+		case key == "len" && n != limit:
+			return fmt.Sprintf("must be exactly %s%s", arg, unit)
+		}
+	case "regexp":
+		re, err := compileRegexp(arg)
+		if err != nil {
+			return "has invalid rule regexp"
+		}
+
+		if !re.MatchString(fmt.Sprint(value.Interface())) {
+			return "is invalid"
+		}
+	case "email":
+		s := fmt.Sprint(value.Interface())
+		address, err := mail.ParseAddress(s)
+		if err != nil || address.Address != s {
+			return "must be a valid email"
+		}
+	case "enum":
+		options := strings.Split(arg, "|")
+		s := fmt.Sprint(value.Interface())
+		for _, option := range options {
+			if option == s {
+				return ""
+			}
+		}
+
+		return fmt.Sprintf("must be one of %s", strings.Join(options, ", "))
+	case "unique":
+		taken, err := isTaken(ctx, model, field.Name, value.Interface())
+		if err != nil {
+			fmt.Println(err)
+			return "can't be checked"
+		}
+
+		if taken {
+			return "has already been taken"
+		}
+	default:
+		return fmt.Sprintf("has unknown rule %s", key)
+	}
+
+	return ""
+}
+
+// isTaken checks if another record has the same value in the column
+func isTaken(ctx context.Context, model interface{}, fieldName string, value interface{}) (bool, error) {
+	scope := db.NewScope(model)
+	field, ok := scope.FieldByName(fieldName)
+	if !ok {
+		return false, fmt.Errorf("unknown field %s", fieldName)
+	}
+
+	qryDB := contextDB(ctx).Table(scope.TableName()).Where(fmt.Sprintf("%s = ?", scope.Quote(field.DBName)), value)
+	if !scope.PrimaryKeyZero() {
+		qryDB = qryDB.Where(fmt.Sprintf("%s <> ?", scope.Quote(scope.PrimaryKey())), scope.PrimaryKeyValue())
+	}
+
+	var count int
+	err := qryDB.Count(&count).Error
+	return count > 0, err
+}
+
+// mergeChanges returns a copy of the stored record with non blank
+// fields of changes applied, which is what Update saves
+func mergeChanges(stored interface{}, changes interface{}) interface{} {
+	merged := reflect.New(reflect.Indirect(reflect.ValueOf(stored)).Type())
+	merged.Elem().Set(reflect.Indirect(reflect.ValueOf(stored)))
+	mergeValue(merged.Elem(), reflect.Indirect(reflect.ValueOf(changes)))
+	return merged.Interface()
+}
+
+func mergeValue(dst reflect.Value, src reflect.Value) {
+	for i := 0; i < src.NumField(); i++ {
+		field := src.Type().Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			mergeValue(dst.Field(i), src.Field(i))
+			continue
+		}
+
+		if !isZeroValue(src.Field(i)) {
+			dst.Field(i).Set(src.Field(i))
+		}
+	}
+}
+
+// validateRecord runs validate tags and Validator of the record
+func validateRecord(request *http.Request, trigger *Trigger, record interface{}) error {
+	err := ValidateModel(request.Context(), record)
+	errs, _ := err.(ValidationErrors)
+	if err != nil && errs == nil {
+		return err
+	}
+
+	if validator, ok := record.(Validator); ok {
+		err = validator.Validate(trigger)
+		if custom, ok := err.(ValidationErrors); ok {
+			if errs == nil {
+				errs = make(ValidationErrors)
+			}
+
+			for field, messages := range custom {
+				errs[field] = append(errs[field], messages...)
+			}
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// validationResponse converts validation error into handler results
+func validationResponse(err error) (int, interface{}, error) {
+	if errs, ok := err.(ValidationErrors); ok {
+		return 422, errs, errs
+	}
+
+	return hookResponse(err)
+}
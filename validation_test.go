@@ -0,0 +1,103 @@
+package goal_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/thomasdao/goal"
+)
+
+func (p *profile) Post(w http.ResponseWriter, request *http.Request) (int, interface{}, error) {
+	return goal.Create(reflect.TypeOf(p), request)
+}
+
+func (p *profile) Put(w http.ResponseWriter, request *http.Request) (int, interface{}, error) {
+	return goal.Update(reflect.TypeOf(p), request)
+}
+
+func (p *profile) Validate(trigger *goal.Trigger) error {
+	if p.Plan == "pro" && p.Age < 18 {
+		return goal.ValidationErrors{"Plan": {"requires an adult"}}
+	}
+
+	return nil
+}
+
+func TestValidation(t *testing.T) {
+	setup()
+	defer tearDown()
+
+	send := func(method string, path string, body string) (int, map[string][]string) {
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		goal.SharedAPI().Mux().ServeHTTP(recorder, req)
+
+		var response struct {
+			Data map[string][]string `json:"data"`
+		}
+		json.Unmarshal(recorder.Body.Bytes(), &response)
+		return recorder.Code, response.Data
+	}
+
+	code, errs := send("POST", "/profile", `{"Handle":"A!", "email":"nope", "Plan":"gold", "Age":5, "Code":"12345"}`)
+	if code != 422 {
+		t.Fatal("Invalid profile should be rejected", code)
+	}
+
+	expected := map[string]string{
+		"Handle": "must be at least 3 characters",
+		"email":  "must be a valid email",
+		"Plan":   "must be one of free, pro",
+		"Age":    "must be at least 13",
+		"Code":   "must be exactly 4 characters",
+	}
+	for field, message := range expected {
+		if len(errs[field]) == 0 || errs[field][0] != message {
+			t.Error("Unexpected errors of", field, errs[field])
+		}
+	}
+
+	if len(errs["Handle"]) != 2 || errs["Handle"][1] != "is invalid" {
+		t.Error("Regexp should be checked", errs["Handle"])
+	}
+
+	code, errs = send("POST", "/profile", `{"Age":20}`)
+	if code != 422 || errs["Handle"][0] != "is required" || errs["email"][0] != "is required" {
+		t.Error("Required fields should be checked", code, errs)
+	}
+
+	// Custom validator
+	code, errs = send("POST", "/profile", `{"Handle":"kid", "email":"kid@example.com", "Plan":"pro", "Age":15}`)
+	if code != 422 || errs["Plan"][0] != "requires an adult" {
+		t.Error("Validator should be called", code, errs)
+	}
+
+	code, _ = send("POST", "/profile", `{"Handle":"thomas", "email":"thomas@example.com", "Plan":"pro", "Age":30}`)
+	if code != 200 {
+		t.Fatal("Valid profile should be created", code)
+	}
+
+	code, errs = send("POST", "/profile", `{"Handle":"thomas", "email":"other@example.com", "Age":30}`)
+	if code != 422 || errs["Handle"][0] != "has already been taken" {
+		t.Error("Handle should be unique", code, errs)
+	}
+
+	// Update validates the stored record with changes applied
+	var stored profile
+	db.Where("handle = ?", "thomas").First(&stored)
+	path := fmt.Sprint("/profile/", stored.ID)
+
+	if code, _ = send("PUT", path, `{"Age":31}`); code != 200 {
+		t.Error("Unchanged handle should not conflict with itself", code)
+	}
+
+	code, errs = send("PUT", path, `{"Age":16}`)
+	if code != 422 || errs["Plan"][0] != "requires an adult" {
+		t.Error("Merged record should be validated", code, errs)
+	}
+}